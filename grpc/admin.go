@@ -0,0 +1,179 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/packethost/pkg/env"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// adminServer is the companion http server exposing metrics, pprof and health endpoints
+type adminServer struct {
+	mu       sync.RWMutex
+	port     int
+	listener net.Listener
+	server   *http.Server
+}
+
+// Admin will run a companion http server next to the grpc server.
+// It serves Prometheus metrics on /metrics, pprof on /debug/pprof/, liveness on /healthz and readiness on /readyz.
+// /readyz only succeeds while the grpc server is serving and, if the Health option is used, the server as a whole is SERVING.
+//
+// The admin server's port is configured via the METRICS_PORT or HTTP_PORT env variables (default 8081), but can be overriden by the AdminPort helper func.
+// The admin server is started by Serve and is shutdown along with the grpc server.
+func Admin() Option {
+	return func(s *Server) {
+		if s.admin == nil {
+			s.admin = &adminServer{}
+		}
+	}
+}
+
+// AdminPort will run a companion http server, see Admin, and set the port it will bind to, port must be > 0
+func AdminPort(port int) Option {
+	return func(s *Server) {
+		if port < 1 {
+			s.err = errors.New("admin port must be > 1")
+		}
+
+		Admin()(s)
+		s.admin.port = port
+	}
+}
+
+// AdminPort returns the port the admin http server is listening on, or 0 if the Admin option was not used
+func (s *Server) AdminPort() int {
+	if s.admin == nil {
+		return 0
+	}
+
+	s.admin.mu.RLock()
+	defer s.admin.mu.RUnlock()
+	return s.admin.port
+}
+
+// maybeSetAdminPortFromEnv will pick up the admin port from the environment, but only if the admin server is enabled and the user hasn't specified the port via `AdminPort`
+func maybeSetAdminPortFromEnv(s *Server) error {
+	if s.admin == nil || s.admin.port != 0 {
+		return nil
+	}
+
+	port, err := strconv.Atoi(env.Get("METRICS_PORT", env.Get("HTTP_PORT", "8081")))
+	if err != nil {
+		return errors.Wrap(err, "parse admin port from env")
+	}
+	if port < 1 {
+		return errors.New("admin port must be > 1")
+	}
+
+	s.admin.port = port
+	return nil
+}
+
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	if g, ok := s.registerer.(prometheus.Gatherer); ok {
+		mux.Handle("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+	} else {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready(r.Context()) {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	return mux
+}
+
+// ready reports whether the grpc server is serving and, if health checking is enabled, whether it reports SERVING
+func (s *Server) ready(ctx context.Context) bool {
+	if atomic.LoadInt32(&s.serving) == 0 {
+		return false
+	}
+	if s.health == nil {
+		return true
+	}
+
+	resp, err := s.health.Check(ctx, &healthpb.HealthCheckRequest{})
+	return err == nil && resp.Status == healthpb.HealthCheckResponse_SERVING
+}
+
+func (a *adminServer) listen() error {
+	a.mu.RLock()
+	port := a.port
+	a.mu.RUnlock()
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return errors.Wrap(err, "admin listen")
+	}
+
+	port = l.Addr().(*net.TCPAddr).Port
+
+	a.mu.Lock()
+	a.listener = l
+	a.port = port
+	a.mu.Unlock()
+
+	return nil
+}
+
+// start runs the admin http server in the background until shutdown is called
+func (a *adminServer) start(handler http.Handler, l log.Logger) {
+	server := &http.Server{Handler: handler}
+
+	a.mu.Lock()
+	a.server = server
+	listener := a.listener
+	a.mu.Unlock()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			l.Error(errors.Wrap(err, "admin serve"))
+		}
+	}()
+}
+
+// shutdown stops the admin http server, waiting for in-flight requests until ctx is done
+func (a *adminServer) shutdown(ctx context.Context) error {
+	a.mu.RLock()
+	server := a.server
+	listener := a.listener
+	a.mu.RUnlock()
+
+	if server == nil {
+		if listener != nil {
+			// Serve was never called, nothing useful to do with the error
+			_ = listener.Close()
+		}
+		return nil
+	}
+	return errors.Wrap(server.Shutdown(ctx), "admin shutdown")
+}
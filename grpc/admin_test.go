@@ -0,0 +1,144 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/internal/testenv"
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func adminGet(t *testing.T, port int, path string) (int, string, error) {
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d%s", port, path))
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(b), nil
+}
+
+func TestAdminPort(t *testing.T) {
+	defer testenv.Clear().Restore()
+
+	l := log.Test(t, svc)
+	assert := require.New(t)
+
+	s, err := NewServer(l, defSrv)
+	assert.NoError(err)
+	assert.Equal(0, s.AdminPort())
+
+	s, err = NewServer(l, defSrv, Admin())
+	assert.NoError(err)
+	assert.Equal(8081, s.AdminPort())
+
+	os.Setenv("HTTP_PORT", "4243")
+	s, err = NewServer(l, defSrv, Admin())
+	assert.NoError(err)
+	assert.Equal(4243, s.AdminPort())
+
+	os.Setenv("METRICS_PORT", "4244")
+	s, err = NewServer(l, defSrv, Admin())
+	assert.NoError(err)
+	assert.Equal(4244, s.AdminPort())
+
+	s, err = NewServer(l, defSrv, AdminPort(2425))
+	assert.NoError(err)
+	assert.Equal(2425, s.AdminPort())
+
+	s, err = NewServer(l, defSrv, AdminPort(2425), Admin())
+	assert.NoError(err)
+	assert.Equal(2425, s.AdminPort())
+
+	os.Setenv("METRICS_PORT", "0")
+	s, err = NewServer(l, defSrv, Admin())
+	assert.Error(err)
+	assert.Nil(s)
+
+	os.Setenv("METRICS_PORT", "metrics")
+	s, err = NewServer(l, defSrv, Admin())
+	assert.Error(err)
+	assert.Nil(s)
+
+	s, err = NewServer(l, defSrv, AdminPort(0))
+	assert.Error(err)
+	assert.Nil(s)
+}
+
+func TestAdmin(t *testing.T) {
+	l := log.Test(t, svc)
+	assert := require.New(t)
+
+	s, err := NewServer(l, defSrv, Admin(), Health())
+	assert.NoError(err)
+	s.admin.port = 0
+
+	serve(t, s, func() {
+		port := s.AdminPort()
+		assert.NotZero(port)
+
+		code, body, err := adminGet(t, port, "/metrics")
+		assert.NoError(err)
+		assert.Equal(http.StatusOK, code)
+		assert.Contains(body, "grpc_server_started_total")
+
+		code, _, err = adminGet(t, port, "/debug/pprof/")
+		assert.NoError(err)
+		assert.Equal(http.StatusOK, code)
+
+		code, _, err = adminGet(t, port, "/healthz")
+		assert.NoError(err)
+		assert.Equal(http.StatusOK, code)
+
+		code, _, err = adminGet(t, port, "/readyz")
+		assert.NoError(err)
+		assert.Equal(http.StatusOK, code)
+
+		s.HealthServer().SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+		code, _, err = adminGet(t, port, "/readyz")
+		assert.NoError(err)
+		assert.Equal(http.StatusServiceUnavailable, code)
+	})
+
+	_, _, err = adminGet(t, s.AdminPort(), "/healthz")
+	assert.Error(err)
+}
+
+func TestAdminShutdown(t *testing.T) {
+	l := log.Test(t, svc)
+	assert := require.New(t)
+
+	s, err := NewServer(l, defSrv, Admin())
+	assert.NoError(err)
+	s.port = 0
+	s.admin.port = 0
+	assert.NoError(s.listen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- s.ServeContext(ctx) }()
+
+	assert.Eventually(func() bool {
+		code, _, err := adminGet(t, s.AdminPort(), "/readyz")
+		return err == nil && code == http.StatusOK
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	assert.NoError(<-serveErr)
+	_, _, err = adminGet(t, s.AdminPort(), "/healthz")
+	assert.Error(err)
+}
@@ -0,0 +1,23 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"github.com/pkg/errors"
+)
+
+// Auth will authenticate RPCs using fn, e.g. the AuthFunc of a grpc/authz Config, via the go-grpc-middleware auth interceptors.
+// Services implementing grpc_auth.ServiceAuthFuncOverride use their own AuthFuncOverride instead, and methods can be excluded using SkipAuth.
+func Auth(fn grpc_auth.AuthFunc) Option {
+	return func(s *Server) {
+		if fn == nil {
+			s.err = errors.New("auth func must not be nil")
+			return
+		}
+
+		s.streamers = append(s.streamers, unlessStream(&s.skips.auth, grpc_auth.StreamServerInterceptor(fn)))
+		s.unariers = append(s.unariers, unlessUnary(&s.skips.auth, grpc_auth.UnaryServerInterceptor(fn)))
+	}
+}
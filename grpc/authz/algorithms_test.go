@@ -0,0 +1,173 @@
+package authz
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	jwt "github.com/cristalhq/jwt/v3"
+	jwt_helper "github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var adminClaims = userClaims{StandardClaims: jwt.StandardClaims{Audience: []string{"admin"}}}
+
+func ecdsaJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": key.Curve.Params().Name,
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+	}
+}
+
+func ed25519JWK(kid string, key ed25519.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "OKP",
+		"kid": kid,
+		"crv": "Ed25519",
+		"x":   base64.RawURLEncoding.EncodeToString(key),
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	rsaKey, err := jwt_helper.ParseRSAPrivateKeyFromPEM([]byte(privKey))
+	require.NoError(t, err)
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	ecKeys := map[jwt.Algorithm]*ecdsa.PrivateKey{}
+	for alg, curve := range map[jwt.Algorithm]elliptic.Curve{jwt.ES256: elliptic.P256(), jwt.ES384: elliptic.P384(), jwt.ES512: elliptic.P521()} {
+		ecKeys[alg], err = ecdsa.GenerateKey(curve, rand.Reader)
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		alg    jwt.Algorithm
+		signer func() (jwt.Signer, error)
+		key    ConfigOption
+	}{
+		{jwt.HS384, func() (jwt.Signer, error) { return jwt.NewSignerHS(jwt.HS384, hsKey) }, WithHSKey(hsKey)},
+		{jwt.RS512, func() (jwt.Signer, error) { return jwt.NewSignerRS(jwt.RS512, rsaKey) }, WithRSAPubKey(&rsaKey.PublicKey)},
+		{jwt.PS256, func() (jwt.Signer, error) { return jwt.NewSignerPS(jwt.PS256, rsaKey) }, WithRSAPubKey(&rsaKey.PublicKey)},
+		{jwt.PS384, func() (jwt.Signer, error) { return jwt.NewSignerPS(jwt.PS384, rsaKey) }, WithRSAPubKey(&rsaKey.PublicKey)},
+		{jwt.PS512, func() (jwt.Signer, error) { return jwt.NewSignerPS(jwt.PS512, rsaKey) }, WithRSAPubKey(&rsaKey.PublicKey)},
+		{jwt.ES256, func() (jwt.Signer, error) { return jwt.NewSignerES(jwt.ES256, ecKeys[jwt.ES256]) }, WithECDSAPubKey(&ecKeys[jwt.ES256].PublicKey)},
+		{jwt.ES384, func() (jwt.Signer, error) { return jwt.NewSignerES(jwt.ES384, ecKeys[jwt.ES384]) }, WithECDSAPubKey(&ecKeys[jwt.ES384].PublicKey)},
+		{jwt.ES512, func() (jwt.Signer, error) { return jwt.NewSignerES(jwt.ES512, ecKeys[jwt.ES512]) }, WithECDSAPubKey(&ecKeys[jwt.ES512].PublicKey)},
+		{jwt.EdDSA, func() (jwt.Signer, error) { return jwt.NewSignerEdDSA(edPriv) }, WithEd25519PubKey(edPub)},
+	}
+	for _, test := range tests {
+		t.Run(test.alg.String(), func(t *testing.T) {
+			signer, err := test.signer()
+			require.NoError(t, err)
+			token := createTokenKID(t, signer, "", adminClaims)
+
+			config := NewConfig(test.alg, map[string][]string{protectedMethod: {}}, WithAudience("admin"), test.key)
+			_, err = config.AuthFunc(authCtx(protectedMethod, token))
+			assert.NoError(t, err)
+
+			// no key configured
+			config = NewConfig(test.alg, map[string][]string{protectedMethod: {}}, WithAudience("admin"))
+			_, err = config.AuthFunc(authCtx(protectedMethod, token))
+			assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+		})
+	}
+}
+
+func TestMultipleAlgorithms(t *testing.T) {
+	oldKey, err := jwt_helper.ParseRSAPrivateKeyFromPEM([]byte(privKey))
+	require.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	// migrating from an RS256 key to an ES256 key
+	config := NewConfig(jwt.RS256, map[string][]string{protectedMethod: {}},
+		WithAudience("admin"),
+		WithAlgorithms(jwt.ES256),
+		WithRSAPubKey(&oldKey.PublicKey),
+		WithECDSAPubKey(&newKey.PublicKey),
+	)
+	auth := func(signer jwt.Signer, err error) codes.Code {
+		require.NoError(t, err)
+		_, err = config.AuthFunc(authCtx(protectedMethod, createTokenKID(t, signer, "", adminClaims)))
+		return status.Code(err)
+	}
+
+	assert.Equal(t, codes.OK, auth(jwt.NewSignerRS(jwt.RS256, oldKey)))
+	assert.Equal(t, codes.OK, auth(jwt.NewSignerES(jwt.ES256, newKey)))
+	assert.Equal(t, codes.Unauthenticated, auth(jwt.NewSignerES(jwt.ES256, otherKey)), "wrong key")
+	assert.Equal(t, codes.Unauthenticated, auth(jwt.NewSignerPS(jwt.PS256, oldKey)), "algorithm not accepted")
+	assert.Equal(t, codes.Unauthenticated, auth(jwt.NewSignerHS(jwt.HS256, hsKey)), "algorithm not accepted")
+
+	// tokens using an accepted but unsupported algorithm
+	config = NewConfig("none", map[string][]string{protectedMethod: {}})
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	_, err = config.AuthFunc(authCtx(protectedMethod, header+".e30."))
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestJWKSAlgorithms(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	idp := newIdentityProvider(t)
+	idp.serve(http.StatusOK, jwksDoc(t, ecdsaJWK("ec", &ecKey.PublicKey), ed25519JWK("ed", edPub)))
+
+	config := NewConfig(jwt.ES384, map[string][]string{protectedMethod: {}},
+		WithAudience("admin"),
+		WithAlgorithms(jwt.EdDSA),
+		WithJWKS(NewJWKSFromURL(idp.URL)),
+	)
+	ecSigner, err := jwt.NewSignerES(jwt.ES384, ecKey)
+	require.NoError(t, err)
+	edSigner, err := jwt.NewSignerEdDSA(edPriv)
+	require.NoError(t, err)
+	auth := func(signer jwt.Signer, kid string) codes.Code {
+		_, err := config.AuthFunc(authCtx(protectedMethod, createTokenKID(t, signer, kid, adminClaims)))
+		return status.Code(err)
+	}
+
+	assert.Equal(t, codes.OK, auth(ecSigner, "ec"))
+	assert.Equal(t, codes.OK, auth(edSigner, "ed"))
+	assert.Equal(t, codes.Unauthenticated, auth(edSigner, "ec"), "key type must match the algorithm")
+	assert.Equal(t, codes.Unauthenticated, auth(ecSigner, "ed"), "key type must match the algorithm")
+}
+
+func TestParseJWKSKeyTypes(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	require.NoError(t, err)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	keys, err := parseJWKS(jwksDoc(t,
+		ecdsaJWK("ec", &ecKey.PublicKey),
+		ed25519JWK("ed", edPub),
+		map[string]string{"kty": "OKP", "kid": "x25519", "crv": "X25519", "x": "AAAA"},
+	))
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+	assert.True(t, ecKey.PublicKey.Equal(keys["ec"]))
+	assert.Equal(t, edPub, keys["ed"])
+
+	offCurve := ecdsaJWK("bad", &ecKey.PublicKey)
+	offCurve["y"] = offCurve["x"]
+	badCurve := ecdsaJWK("bad", &ecKey.PublicKey)
+	badCurve["crv"] = "secp256k1"
+	shortEd := ed25519JWK("bad", edPub[:16])
+	for name, jwk := range map[string]map[string]string{"off curve": offCurve, "unsupported curve": badCurve, "short ed25519": shortEd} {
+		_, err := parseJWKS(jwksDoc(t, jwk))
+		assert.Error(t, err, name)
+	}
+}
@@ -2,8 +2,12 @@ package authz
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	jwt "github.com/cristalhq/jwt/v3"
@@ -16,11 +20,13 @@ import (
 const authorizationType = "bearer"
 
 // Config auth details
+// A Config must not be modified once AuthFunc is in use, AuthFunc itself is safe for concurrent use by multiple RPCs.
 type Config struct {
+	// Algorithm tokens are signed with
 	Algorithm jwt.Algorithm
-	// scopes will be populated from the token that
-	// comes with the request
-	scopes []string
+	// Algorithms tokens may also be signed with, e.g. while migrating signing keys.
+	// The token's alg header selects which one it is verified with.
+	Algorithms []jwt.Algorithm
 	// ScopeMapping should hold full rpc methods, given by
 	// grpc.UnaryServerInfo.FullMethod, mapped to a slice of
 	// allowed scopes. Only the methods here will be protected
@@ -29,13 +35,32 @@ type Config struct {
 	// ValidateScopeFunc is a user defined func for validating a token
 	// has the correct scopes. This will take in the decoded token json and
 	// unmarshal into any struct the user wants. See the test files for examples.
-	ValidateScopeFunc         func(tokenClaims []byte, scopes []string) error
-	Audience                  string
+	ValidateScopeFunc func(tokenClaims []byte, scopes []string) error
+	// Audience the token must be for, unless DisableAudienceValidation is set
+	Audience string
+	// Audiences the token may be for instead of Audience
+	Audiences                 []string
 	DisableAudienceValidation bool
+	// Issuer the token must be issued by, if set
+	Issuer string
+	// Leeway allowed for clock skew when validating the exp, nbf and iat claims
+	Leeway time.Duration
+	// RequiredClaims must be present in the token, e.g. exp, which is otherwise optional
+	RequiredClaims []string
+	// MaxTokenAge is how long after it was issued a token is accepted for, if set.
+	// Tokens must have an iat claim when it is set.
+	MaxTokenAge time.Duration
 	// HSKey for use with HS algorithms
 	HSKey []byte
-	// RSAPublicKey for use with RS algorithms
+	// RSAPublicKey for use with RS and PS algorithms
 	RSAPublicKey *rsa.PublicKey
+	// ECDSAPublicKey for use with ES algorithms
+	ECDSAPublicKey *ecdsa.PublicKey
+	// Ed25519PublicKey for use with the EdDSA algorithm
+	Ed25519PublicKey ed25519.PublicKey
+	// JWKS for use with RS, PS, ES and EdDSA algorithms instead of the public keys above,
+	// the key is selected by the token's kid header
+	JWKS *JWKS
 }
 
 // ConfigOption for setting optional values
@@ -51,6 +76,32 @@ func WithAudience(aud string) ConfigOption {
 	return func(args *Config) { args.Audience = aud }
 }
 
+// WithAudiences adds audiences the token may be for instead of the one set by WithAudience,
+// e.g. to accept tokens from several identity providers
+func WithAudiences(auds ...string) ConfigOption {
+	return func(args *Config) { args.Audiences = append(args.Audiences, auds...) }
+}
+
+// WithIssuer sets the issuer the token must be issued by
+func WithIssuer(iss string) ConfigOption {
+	return func(args *Config) { args.Issuer = iss }
+}
+
+// WithLeeway sets the leeway allowed for clock skew
+func WithLeeway(leeway time.Duration) ConfigOption {
+	return func(args *Config) { args.Leeway = leeway }
+}
+
+// WithRequiredClaims adds claims that must be present in the token
+func WithRequiredClaims(claims ...string) ConfigOption {
+	return func(args *Config) { args.RequiredClaims = append(args.RequiredClaims, claims...) }
+}
+
+// WithMaxTokenAge sets how long after it was issued a token is accepted for
+func WithMaxTokenAge(age time.Duration) ConfigOption {
+	return func(args *Config) { args.MaxTokenAge = age }
+}
+
 // WithDisableAudienceValidation sets the WithDisableAudienceValidation option
 func WithDisableAudienceValidation(disable bool) ConfigOption {
 	return func(args *Config) { args.DisableAudienceValidation = disable }
@@ -66,6 +117,26 @@ func WithRSAPubKey(rsaPubKey *rsa.PublicKey) ConfigOption {
 	return func(args *Config) { args.RSAPublicKey = rsaPubKey }
 }
 
+// WithECDSAPubKey sets the ECDSA public key
+func WithECDSAPubKey(ecdsaPubKey *ecdsa.PublicKey) ConfigOption {
+	return func(args *Config) { args.ECDSAPublicKey = ecdsaPubKey }
+}
+
+// WithEd25519PubKey sets the Ed25519 public key
+func WithEd25519PubKey(ed25519PubKey ed25519.PublicKey) ConfigOption {
+	return func(args *Config) { args.Ed25519PublicKey = ed25519PubKey }
+}
+
+// WithAlgorithms adds algorithms tokens may also be signed with, each needs its key to be set too
+func WithAlgorithms(algs ...jwt.Algorithm) ConfigOption {
+	return func(args *Config) { args.Algorithms = append(args.Algorithms, algs...) }
+}
+
+// WithJWKS sets the JWKS keys are selected from
+func WithJWKS(jwks *JWKS) ConfigOption {
+	return func(args *Config) { args.JWKS = jwks }
+}
+
 // NewConfig returns a new config with options
 func NewConfig(algo jwt.Algorithm, scopeMapping map[string][]string, opts ...ConfigOption) *Config {
 	defaultConfig := &Config{
@@ -80,35 +151,128 @@ func NewConfig(algo jwt.Algorithm, scopeMapping map[string][]string, opts ...Con
 }
 
 // AuthFunc authorization function
+// The returned context carries the token's claims for protected methods, see ClaimsFromContext.
 func (c *Config) AuthFunc(ctx context.Context) (context.Context, error) {
-	token, protected, err := c.doProtected(ctx)
+	token, scopes, protected, err := c.doProtected(ctx)
 	if err != nil {
 		return ctx, err
 	}
 	if !protected {
 		return ctx, nil
 	}
+	verifier, err := c.verifier(ctx, token)
+	if err != nil {
+		return ctx, err
+	}
+
+	rawToken, err := c.doVerify(ctx, token, verifier)
+	if err != nil {
+		return ctx, err
+	}
+	if err := c.ValidateScopeFunc(rawToken, scopes); err != nil {
+		return ctx, err
+	}
+
+	return context.WithValue(ctx, claimsKey{}, parseClaims(rawToken)), nil
+}
+
+// verifier returns a verifier for the algorithm the token was signed with, which must be one of the accepted algorithms
+func (c *Config) verifier(ctx context.Context, token string) (jwt.Verifier, error) {
+	parsed, err := jwt.ParseString(token)
+	if err != nil {
+		return nil, unauthenticatedError(err.Error())
+	}
+	alg := parsed.Header().Algorithm
+	if !c.accepts(alg) {
+		return nil, unauthenticatedError(fmt.Sprintf("algorithm %s is not accepted", alg))
+	}
+
 	var verifier jwt.Verifier
-	switch c.Algorithm {
+	switch alg {
 	case jwt.HS256, jwt.HS384, jwt.HS512:
-		verifier, err = jwt.NewVerifierHS(c.Algorithm, c.HSKey)
-		if err != nil {
-			return ctx, status.Errorf(codes.FailedPrecondition, "verifier error: %v", err.Error())
+		verifier, err = jwt.NewVerifierHS(alg, c.HSKey)
+	case jwt.RS256, jwt.RS384, jwt.RS512, jwt.PS256, jwt.PS384, jwt.PS512:
+		key := c.RSAPublicKey
+		if c.JWKS != nil {
+			k, err := c.jwksKey(ctx, token)
+			if err != nil {
+				return nil, err
+			}
+			var ok bool
+			if key, ok = k.(*rsa.PublicKey); !ok {
+				return nil, unauthenticatedError("signing key doesn't match the algorithm")
+			}
+		}
+		if alg[0] == 'R' {
+			verifier, err = jwt.NewVerifierRS(alg, key)
+		} else {
+			verifier, err = jwt.NewVerifierPS(alg, key)
 		}
-	case jwt.RS256, jwt.RS384, jwt.RS512:
-		verifier, err = jwt.NewVerifierRS(c.Algorithm, c.RSAPublicKey)
-		if err != nil {
-			return ctx, status.Errorf(codes.FailedPrecondition, "verifier error: %v", err.Error())
+	case jwt.ES256, jwt.ES384, jwt.ES512:
+		key := c.ECDSAPublicKey
+		if c.JWKS != nil {
+			k, err := c.jwksKey(ctx, token)
+			if err != nil {
+				return nil, err
+			}
+			var ok bool
+			if key, ok = k.(*ecdsa.PublicKey); !ok {
+				return nil, unauthenticatedError("signing key doesn't match the algorithm")
+			}
 		}
+		verifier, err = jwt.NewVerifierES(alg, key)
+	case jwt.EdDSA:
+		key := c.Ed25519PublicKey
+		if c.JWKS != nil {
+			k, err := c.jwksKey(ctx, token)
+			if err != nil {
+				return nil, err
+			}
+			var ok bool
+			if key, ok = k.(ed25519.PublicKey); !ok {
+				return nil, unauthenticatedError("signing key doesn't match the algorithm")
+			}
+		}
+		verifier, err = jwt.NewVerifierEdDSA(key)
 	default:
-		return ctx, status.Errorf(codes.Unimplemented, "algorithm is not supported: %T", c.Algorithm)
+		return nil, status.Errorf(codes.Unimplemented, "algorithm is not supported: %s", alg)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "verifier error: %v", err.Error())
 	}
+	return verifier, nil
+}
 
-	rawToken, err := c.doVerify(ctx, token, verifier)
+// accepts reports whether tokens signed with alg are accepted
+func (c *Config) accepts(alg jwt.Algorithm) bool {
+	if alg == c.Algorithm {
+		return true
+	}
+	for _, a := range c.Algorithms {
+		if alg == a {
+			return true
+		}
+	}
+	return false
+}
+
+// jwksKey returns the key selected by the token's kid header from the JWKS
+func (c *Config) jwksKey(ctx context.Context, token string) (interface{}, error) {
+	kid, err := keyID(token)
 	if err != nil {
-		return ctx, err
+		return nil, unauthenticatedError(err.Error())
+	}
+	key, err := c.JWKS.key(ctx, kid)
+	if errors.Is(err, errUnknownKey) {
+		return nil, unauthenticatedError(err.Error())
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "signing keys are unavailable: %v", err.Error())
 	}
-	return ctx, c.ValidateScopeFunc(rawToken, c.scopes)
+	return key, nil
 }
 
 func unauthenticatedError(msg string) error {
@@ -120,18 +284,18 @@ func permissionDeniedError(msg string) error {
 }
 
 // doProtected checks if the method should be protected by auth or not. If so, then scopes
-// for the method are saved to Config.scopes for later use.
-func (c *Config) doProtected(ctx context.Context) (token string, protected bool, err error) {
+// for the method are returned along with the token for later use.
+func (c *Config) doProtected(ctx context.Context) (token string, scopes []string, protected bool, err error) {
 	fullMethodName, _ := grpc.Method(ctx)
-	c.scopes, protected = c.ScopeMapping[fullMethodName]
+	scopes, protected = c.ScopeMapping[fullMethodName]
 	if !protected {
-		return token, false, nil
+		return token, nil, false, nil
 	}
 	token, err = grpc_auth.AuthFromMD(ctx, authorizationType)
 	if err != nil {
-		return token, protected, err
+		return token, scopes, protected, err
 	}
-	return token, true, nil
+	return token, scopes, true, nil
 }
 
 // doVerify runs some standard JWT validations against a token
@@ -147,24 +311,60 @@ func (c *Config) doVerify(ctx context.Context, token string, verifier jwt.Verifi
 		return nil, unauthenticatedError(err.Error())
 	}
 
-	// Perform standard JWT validations
-	if !newClaims.IsValidAt(time.Now()) {
-		return nil, unauthenticatedError("access token is invalid: not valid")
+	// Perform standard JWT validations, allowing for clock skew
+	now := time.Now()
+	if !newClaims.IsValidNotBefore(now.Add(c.Leeway)) || !newClaims.IsValidIssuedAt(now.Add(c.Leeway)) {
+		return nil, unauthenticatedError("not valid yet")
 	}
-	if !newClaims.IsValidExpiresAt(time.Now()) {
-		return nil, unauthenticatedError("access token is invalid: expired")
+	if !newClaims.IsValidExpiresAt(now.Add(-c.Leeway)) {
+		return nil, unauthenticatedError("expired")
+	}
+	if c.MaxTokenAge > 0 {
+		if newClaims.IssuedAt == nil {
+			return nil, unauthenticatedError("missing iat claim")
+		}
+		if now.Add(-c.Leeway).Sub(newClaims.IssuedAt.Time) > c.MaxTokenAge {
+			return nil, unauthenticatedError("too old")
+		}
 	}
 
-	// Perform audience claim validation
-	if !c.DisableAudienceValidation {
-		if !newClaims.IsForAudience(c.Audience) {
-			return nil, unauthenticatedError("not for audience")
+	if len(c.RequiredClaims) > 0 {
+		var present map[string]json.RawMessage
+		if err := json.Unmarshal(newToken.RawClaims(), &present); err != nil {
+			return nil, unauthenticatedError(err.Error())
 		}
+		for _, claim := range c.RequiredClaims {
+			if v, ok := present[claim]; !ok || string(v) == "null" {
+				return nil, unauthenticatedError("missing " + claim + " claim")
+			}
+		}
+	}
+
+	if c.Issuer != "" && !newClaims.IsIssuer(c.Issuer) {
+		return nil, unauthenticatedError("not from issuer")
+	}
+
+	// Perform audience claim validation
+	if !c.DisableAudienceValidation && !c.forAudience(newClaims) {
+		return nil, unauthenticatedError("not for audience")
 	}
 
 	return newToken.RawClaims(), nil
 }
 
+// forAudience reports whether the token is for any of the accepted audiences
+func (c *Config) forAudience(claims jwt.StandardClaims) bool {
+	if claims.IsForAudience(c.Audience) {
+		return true
+	}
+	for _, aud := range c.Audiences {
+		if claims.IsForAudience(aud) {
+			return true
+		}
+	}
+	return false
+}
+
 func contains(s []string, str string) bool {
 	for _, v := range s {
 		if v == str {
@@ -2,8 +2,13 @@ package authz
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
 	"fmt"
 	"testing"
+	"time"
 
 	jwt "github.com/cristalhq/jwt/v3"
 	jwt_helper "github.com/dgrijalva/jwt-go"
@@ -60,17 +65,33 @@ func TestNewConfig(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519PubKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	expectedConfig := &Config{
 		Algorithm:    jwt.HS256,
+		Algorithms:   []jwt.Algorithm{jwt.ES256, jwt.EdDSA},
 		ScopeMapping: map[string][]string{"one": {"one"}},
 		ValidateScopeFunc: func(tokenClaims []byte, scopes []string) error {
 			return nil
 		},
 		Audience:                  "admin",
+		Audiences:                 []string{"staging", "production"},
 		DisableAudienceValidation: true,
+		Issuer:                    "https://idp.example.com",
+		Leeway:                    time.Second,
+		RequiredClaims:            []string{"exp", "sub"},
+		MaxTokenAge:               time.Hour,
 		HSKey:                     hsKey,
 		RSAPublicKey:              rsaPubKey,
+		ECDSAPublicKey:            &ecdsaKey.PublicKey,
+		Ed25519PublicKey:          ed25519PubKey,
 	}
 
 	config := NewConfig(
@@ -78,9 +99,18 @@ func TestNewConfig(t *testing.T) {
 		map[string][]string{"one": {"one"}},
 		WithValidateScopeFunc(func(tokenClaims []byte, scopes []string) error { return nil }),
 		WithAudience("admin"),
+		WithAudiences("staging", "production"),
 		WithDisableAudienceValidation(true),
+		WithIssuer("https://idp.example.com"),
+		WithLeeway(time.Second),
+		WithRequiredClaims("exp", "sub"),
+		WithMaxTokenAge(time.Hour),
 		WithHSKey(hsKey),
 		WithRSAPubKey(rsaPubKey),
+		WithECDSAPubKey(&ecdsaKey.PublicKey),
+		WithEd25519PubKey(ed25519PubKey),
+		WithAlgorithms(jwt.ES256),
+		WithAlgorithms(jwt.EdDSA),
 	)
 
 	if diff := cmp.Diff(expectedConfig, config, cmpopts.IgnoreFields(Config{}, "ValidateScopeFunc"), cmpopts.IgnoreUnexported(Config{})); diff != "" {
@@ -0,0 +1,76 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	jwt "github.com/cristalhq/jwt/v3"
+)
+
+// Claims are the verified claims of the token an RPC was authenticated with
+type Claims struct {
+	// Subject is the sub claim, who the token was issued to
+	Subject string
+	// Issuer is the iss claim
+	Issuer string
+	// Audience is the aud claim
+	Audience []string
+	// Scopes are taken from the scopes claim, or the scope claim, either of which may be a list or a space separated string
+	Scopes []string
+	// Raw holds the json of all the claims, for use with json.Unmarshal
+	Raw json.RawMessage
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the token the RPC was authenticated with by AuthFunc, if any.
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
+
+// SubjectFromContext returns the subject of the token the RPC was authenticated with by AuthFunc, or "" for unprotected methods.
+// It can be used with the grpc package's LogSubject to record the subject on each request's log lines.
+func SubjectFromContext(ctx context.Context) string {
+	claims, _ := ClaimsFromContext(ctx)
+	return claims.Subject
+}
+
+// parseClaims returns the Claims of a token's raw claims.
+// It never fails, the token has already been verified, so claims that don't have the expected shape are left empty.
+func parseClaims(raw []byte) Claims {
+	var standard jwt.StandardClaims
+	_ = json.Unmarshal(raw, &standard)
+
+	var parsed struct {
+		Scopes json.RawMessage `json:"scopes"`
+		Scope  json.RawMessage `json:"scope"`
+	}
+	_ = json.Unmarshal(raw, &parsed)
+
+	scopes := parseScopes(parsed.Scopes)
+	if len(scopes) == 0 {
+		scopes = parseScopes(parsed.Scope)
+	}
+	return Claims{
+		Subject:  standard.Subject,
+		Issuer:   standard.Issuer,
+		Audience: standard.Audience,
+		Scopes:   scopes,
+		Raw:      json.RawMessage(raw),
+	}
+}
+
+// parseScopes returns the scopes of a scope claim, which is either a list or a space separated string
+func parseScopes(raw json.RawMessage) []string {
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+	var spaced string
+	if err := json.Unmarshal(raw, &spaced); err == nil {
+		return strings.Fields(spaced)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package authz
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	jwt "github.com/cristalhq/jwt/v3"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	grpc_testing "github.com/grpc-ecosystem/go-grpc-middleware/testing"
+	pb_testproto "github.com/grpc-ecosystem/go-grpc-middleware/testing/testproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+)
+
+func TestClaimsFromContext(t *testing.T) {
+	signer, err := jwt.NewSignerHS(jwt.HS256, hsKey)
+	require.NoError(t, err)
+	config := NewConfig(jwt.HS256, map[string][]string{protectedMethod: {}}, WithHSKey(hsKey), WithAudience("admin"))
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		want   Claims
+	}{
+		{
+			name:   "scopes",
+			claims: map[string]interface{}{"sub": "user@example.com", "iss": "https://idp.example.com", "aud": "admin", "scopes": []string{"read", "write"}, "org": "packet"},
+			want:   Claims{Subject: "user@example.com", Issuer: "https://idp.example.com", Audience: []string{"admin"}, Scopes: []string{"read", "write"}},
+		},
+		{
+			name:   "space separated scope",
+			claims: map[string]interface{}{"sub": "machine", "aud": []string{"admin", "staging"}, "scope": "read  write"},
+			want:   Claims{Subject: "machine", Audience: []string{"admin", "staging"}, Scopes: []string{"read", "write"}},
+		},
+		{
+			name:   "space separated scopes",
+			claims: map[string]interface{}{"sub": "machine", "aud": "admin", "scopes": "read write"},
+			want:   Claims{Subject: "machine", Audience: []string{"admin"}, Scopes: []string{"read", "write"}},
+		},
+		{
+			name:   "scope list",
+			claims: map[string]interface{}{"sub": "machine", "aud": "admin", "scope": []string{"read", "write"}},
+			want:   Claims{Subject: "machine", Audience: []string{"admin"}, Scopes: []string{"read", "write"}},
+		},
+		{
+			name:   "unexpected scopes",
+			claims: map[string]interface{}{"sub": "machine", "aud": "admin", "scopes": map[string]bool{"read": true}, "scope": 1},
+			want:   Claims{Subject: "machine", Audience: []string{"admin"}},
+		},
+		{
+			name:   "no subject",
+			claims: map[string]interface{}{"aud": "admin"},
+			want:   Claims{Audience: []string{"admin"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, err := config.AuthFunc(authCtx(protectedMethod, createTokenKID(t, signer, "", test.claims)))
+			require.NoError(t, err)
+
+			claims, ok := ClaimsFromContext(ctx)
+			require.True(t, ok)
+			raw := claims.Raw
+			claims.Raw = nil
+			assert.Equal(t, test.want, claims)
+			assert.Equal(t, test.want.Subject, SubjectFromContext(ctx))
+
+			var all map[string]interface{}
+			require.NoError(t, json.Unmarshal(raw, &all))
+			assert.Equal(t, test.claims["sub"], all["sub"])
+			assert.Equal(t, test.claims["org"], all["org"])
+		})
+	}
+
+	// unprotected methods don't carry claims
+	ctx, err := config.AuthFunc(authCtx("/mwitkow.testproto.TestService/PingEmpty", ""))
+	require.NoError(t, err)
+	_, ok := ClaimsFromContext(ctx)
+	assert.False(t, ok)
+	assert.Empty(t, SubjectFromContext(ctx))
+}
+
+// subjectPingService echoes the subject of the caller's token
+type subjectPingService struct {
+	pb_testproto.TestServiceServer
+}
+
+func (s *subjectPingService) Ping(ctx context.Context, ping *pb_testproto.PingRequest) (*pb_testproto.PingResponse, error) {
+	return &pb_testproto.PingResponse{Value: SubjectFromContext(ctx)}, nil
+}
+
+type AuthzClaimsTestSuite struct {
+	*grpc_testing.InterceptorTestSuite
+}
+
+func TestClaimsTestSuite(t *testing.T) {
+	a := NewConfig(jwt.HS256, map[string][]string{protectedMethod: {}}, WithHSKey(hsKey), WithAudience("admin"))
+	s := &AuthzClaimsTestSuite{
+		InterceptorTestSuite: &grpc_testing.InterceptorTestSuite{
+			TestService: &subjectPingService{&grpc_testing.TestPingService{T: t}},
+			ServerOpts: []grpc.ServerOption{
+				grpc.StreamInterceptor(grpc_auth.StreamServerInterceptor(a.AuthFunc)),
+				grpc.UnaryInterceptor(grpc_auth.UnaryServerInterceptor(a.AuthFunc)),
+			},
+		},
+	}
+	suite.Run(t, s)
+}
+
+func (s *AuthzClaimsTestSuite) TestUnary_ClaimsReachHandler() {
+	signer, err := jwt.NewSignerHS(jwt.HS256, hsKey)
+	s.Require().NoError(err)
+	token := createTokenKID(s.T(), signer, "", jwt.StandardClaims{Subject: "user@example.com", Audience: []string{"admin"}})
+
+	resp, err := s.Client.Ping(ctxWithToken(s.SimpleCtx(), "bearer", token), goodPing)
+	s.Require().NoError(err)
+	s.Equal("user@example.com", resp.Value)
+}
@@ -0,0 +1,136 @@
+package authz
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+
+	jwt "github.com/cristalhq/jwt/v3"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	grpc_testing "github.com/grpc-ecosystem/go-grpc-middleware/testing"
+	pb_testproto "github.com/grpc-ecosystem/go-grpc-middleware/testing/testproto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const parallelRPCs = 50
+
+type AuthzConcurrencyTestSuite struct {
+	*grpc_testing.InterceptorTestSuite
+	readToken string
+	listToken string
+}
+
+// requireAllScopes only lets a token through if it has every scope of the method being called
+func requireAllScopes(tokenClaims []byte, scopes []string) error {
+	var claims userClaims
+	if err := json.Unmarshal(tokenClaims, &claims); err != nil {
+		return unauthenticatedError(err.Error())
+	}
+	for _, scope := range scopes {
+		if !contains(claims.Scopes, scope) {
+			return permissionDeniedError("missing scope " + scope)
+		}
+	}
+	return nil
+}
+
+func TestConcurrencyTestSuite(t *testing.T) {
+	a := NewConfig(jwt.HS256,
+		map[string][]string{
+			"/mwitkow.testproto.TestService/Ping":      {"read"},
+			"/mwitkow.testproto.TestService/PingEmpty": {"write"},
+			"/mwitkow.testproto.TestService/PingList":  {"list"},
+		},
+		WithAudience("admin"),
+		WithHSKey(hsKey),
+		WithValidateScopeFunc(requireAllScopes),
+	)
+
+	readToken, err := createTokenHS([]string{"read"}, jwt.HS256, hsKey, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listToken, err := createTokenHS([]string{"list"}, jwt.HS256, hsKey, "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &AuthzConcurrencyTestSuite{
+		InterceptorTestSuite: &grpc_testing.InterceptorTestSuite{
+			TestService: &grpc_testing.TestPingService{T: t},
+			ServerOpts: []grpc.ServerOption{
+				grpc.StreamInterceptor(grpc_auth.StreamServerInterceptor(a.AuthFunc)),
+				grpc.UnaryInterceptor(grpc_auth.UnaryServerInterceptor(a.AuthFunc)),
+			},
+		},
+		readToken: readToken.String(),
+		listToken: listToken.String(),
+	}
+	suite.Run(t, s)
+}
+
+// pingList returns the status code of a PingList call made with token
+func (s *AuthzConcurrencyTestSuite) pingList(token string) codes.Code {
+	stream, err := s.Client.PingList(ctxWithToken(s.SimpleCtx(), "bearer", token), goodPing)
+	if err != nil {
+		return status.Code(err)
+	}
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			return codes.OK
+		}
+		if err != nil {
+			return status.Code(err)
+		}
+	}
+}
+
+func (s *AuthzConcurrencyTestSuite) TestParallel_ScopesPerMethod() {
+	var wg sync.WaitGroup
+	for i := 0; i < parallelRPCs; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			_, err := s.Client.Ping(ctxWithToken(s.SimpleCtx(), "bearer", s.readToken), goodPing)
+			assert.NoError(s.T(), err, "read token must be allowed to Ping")
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := s.Client.PingEmpty(ctxWithToken(s.SimpleCtx(), "bearer", s.readToken), &pb_testproto.Empty{})
+			assert.Equal(s.T(), codes.PermissionDenied, status.Code(err), "read token must not be allowed to PingEmpty")
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Equal(s.T(), codes.OK, s.pingList(s.listToken), "list token must be allowed to PingList")
+		}()
+		go func() {
+			defer wg.Done()
+			assert.Equal(s.T(), codes.PermissionDenied, s.pingList(s.readToken), "read token must not be allowed to PingList")
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *AuthzConcurrencyTestSuite) TestParallel_Unprotected() {
+	var wg sync.WaitGroup
+	for i := 0; i < parallelRPCs; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := s.Client.PingError(s.SimpleCtx(), &pb_testproto.PingRequest{ErrorCodeReturned: uint32(codes.NotFound)})
+			assert.Equal(s.T(), codes.NotFound, status.Code(err), "unprotected methods must reach the handler without a token")
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := s.Client.Ping(ctxWithToken(s.SimpleCtx(), "bearer", s.listToken), goodPing)
+			assert.Equal(s.T(), codes.PermissionDenied, status.Code(err), "list token must not be allowed to Ping")
+		}()
+	}
+	wg.Wait()
+}
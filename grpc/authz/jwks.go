@@ -0,0 +1,345 @@
+package authz
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	jwt "github.com/cristalhq/jwt/v3"
+)
+
+const (
+	defaultJWKSTTL        = 15 * time.Minute
+	defaultJWKSMinBackoff = time.Second
+	defaultJWKSMaxBackoff = 5 * time.Minute
+	defaultJWKSTimeout    = 10 * time.Second
+	// maxJWKSSize caps how much of a JWKS document is read
+	maxJWKSSize = 1 << 20
+)
+
+var errUnknownKey = errors.New("unknown key id")
+
+// JWKS is a set of public keys, fetched from a JSON Web Key Set document, that tokens are verified with.
+// Keys are selected by the token's kid header and cached for a TTL.
+// A token with a kid that isn't in the set triggers a refresh, so keys can be rotated without a redeploy.
+// Refreshes that fail or don't turn up the kid are retried with an exponential backoff.
+// A JWKS is safe for concurrent use.
+type JWKS struct {
+	fetch      func(ctx context.Context) ([]byte, error)
+	ttl        time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	timeout    time.Duration
+	client     *http.Client
+
+	// refresh serializes fetches, so a burst of requests with an unknown kid triggers a single one
+	refresh sync.Mutex
+
+	mu      sync.RWMutex
+	keys    map[string]interface{}
+	expires time.Time
+	next    time.Time
+	backoff time.Duration
+}
+
+// JWKSOption for setting optional JWKS values
+type JWKSOption func(*JWKS)
+
+// WithJWKSTTL sets how long fetched keys are used for before the set is fetched again
+func WithJWKSTTL(ttl time.Duration) JWKSOption {
+	return func(j *JWKS) { j.ttl = ttl }
+}
+
+// WithJWKSBackoff sets the bounds of the exponential backoff between refreshes that failed, or didn't find the kid being looked for
+func WithJWKSBackoff(min, max time.Duration) JWKSOption {
+	return func(j *JWKS) {
+		j.minBackoff = min
+		j.maxBackoff = max
+	}
+}
+
+// WithJWKSTimeout sets how long fetching the set may take.
+// Fetches are shared by all callers waiting on a key, so they aren't bound to any one caller's context.
+func WithJWKSTimeout(timeout time.Duration) JWKSOption {
+	return func(j *JWKS) { j.timeout = timeout }
+}
+
+// WithJWKSHTTPClient sets the http client used to fetch the set from a URL
+func WithJWKSHTTPClient(c *http.Client) JWKSOption {
+	return func(j *JWKS) { j.client = c }
+}
+
+func newJWKS(fetch func(ctx context.Context) ([]byte, error), opts ...JWKSOption) *JWKS {
+	j := &JWKS{
+		fetch:      fetch,
+		ttl:        defaultJWKSTTL,
+		minBackoff: defaultJWKSMinBackoff,
+		maxBackoff: defaultJWKSMaxBackoff,
+		timeout:    defaultJWKSTimeout,
+		client:     http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// NewJWKSFromURL returns a JWKS that fetches the set from url, e.g. an identity provider's jwks_uri
+func NewJWKSFromURL(url string, opts ...JWKSOption) *JWKS {
+	var j *JWKS
+	j = newJWKS(func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := j.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status fetching %s: %s", url, resp.Status)
+		}
+		return ioutil.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	}, opts...)
+	return j
+}
+
+// NewJWKSFromFile returns a JWKS that loads the set from the file at path, which is re-read once the TTL has passed
+func NewJWKSFromFile(path string, opts ...JWKSOption) *JWKS {
+	return newJWKS(func(context.Context) ([]byte, error) {
+		return ioutil.ReadFile(path)
+	}, opts...)
+}
+
+// jsonWebKey holds the JWK members of the supported key types
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS returns the signing keys of a JWKS document by kid, keys of unsupported types are skipped
+func parseJWKS(doc []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(doc, &set); err != nil {
+		return nil, fmt.Errorf("parse jwks: %w", err)
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		var err error
+		switch {
+		case k.Kty == "RSA":
+			key, err = k.rsaPublicKey()
+		case k.Kty == "EC":
+			key, err = k.ecdsaPublicKey()
+		case k.Kty == "OKP" && k.Crv == "Ed25519":
+			key, err = k.ed25519PublicKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse jwk %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("decode modulus: %w", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("decode exponent: %w", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 2 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid rsa key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func (k jsonWebKey) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("decode x: %w", err)
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, fmt.Errorf("decode y: %w", err)
+	}
+	key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if !curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("invalid ecdsa key")
+	}
+	return key, nil
+}
+
+func (k jsonWebKey) ed25519PublicKey() (ed25519.PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, fmt.Errorf("decode x: %w", err)
+	}
+	if len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid ed25519 key")
+	}
+	return ed25519.PublicKey(x), nil
+}
+
+// key returns the key with id kid, refreshing the set if it has expired or doesn't have kid.
+// If ctx is done before a refresh finishes, ctx's error is returned and the refresh carries on for the next caller.
+func (j *JWKS) key(ctx context.Context, kid string) (interface{}, error) {
+	key, ok, fresh := j.cached(kid)
+	if ok && fresh {
+		return key, nil
+	}
+
+	type result struct {
+		key interface{}
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		key, err := j.refreshKey(kid)
+		done <- result{key: key, err: err}
+	}()
+	select {
+	case r := <-done:
+		return r.key, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refreshKey refreshes the set, unless it's backing off, and returns the key with id kid
+func (j *JWKS) refreshKey(kid string) (interface{}, error) {
+	j.refresh.Lock()
+	defer j.refresh.Unlock()
+
+	// the set may have been refreshed while waiting for the lock
+	key, ok, fresh := j.cached(kid)
+	if ok && fresh {
+		return key, nil
+	}
+
+	j.mu.RLock()
+	next := j.next
+	j.mu.RUnlock()
+	if time.Now().Before(next) {
+		// backing off, a stale key is better than none
+		if ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("%w %q", errUnknownKey, kid)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), j.timeout)
+	defer cancel()
+	doc, err := j.fetch(ctx)
+	var keys map[string]interface{}
+	if err == nil {
+		keys, err = parseJWKS(doc)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err != nil {
+		j.backOff()
+		if ok {
+			return key, nil
+		}
+		return nil, fmt.Errorf("refresh jwks: %w", err)
+	}
+
+	j.keys = keys
+	j.expires = time.Now().Add(j.ttl)
+	key, ok = keys[kid]
+	if !ok {
+		j.backOff()
+		return nil, fmt.Errorf("%w %q", errUnknownKey, kid)
+	}
+	j.backoff = 0
+	j.next = time.Time{}
+	return key, nil
+}
+
+// cached returns the key with id kid, if any, and whether the set is still fresh
+func (j *JWKS) cached(kid string) (key interface{}, ok bool, fresh bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	key, ok = j.keys[kid]
+	return key, ok, time.Now().Before(j.expires)
+}
+
+// backOff doubles the time until the next refresh is allowed, must be called with mu held
+func (j *JWKS) backOff() {
+	switch {
+	case j.backoff == 0:
+		j.backoff = j.minBackoff
+	case j.backoff < j.maxBackoff:
+		j.backoff *= 2
+	}
+	if j.backoff > j.maxBackoff {
+		j.backoff = j.maxBackoff
+	}
+	j.next = time.Now().Add(j.backoff)
+}
+
+// keyID returns the kid header of token, which the jwt package doesn't expose
+func keyID(token string) (string, error) {
+	parsed, err := jwt.ParseString(token)
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(string(parsed.RawHeader()))
+	if err != nil {
+		return "", err
+	}
+	var header struct {
+		KeyID string `json:"kid"`
+	}
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return "", err
+	}
+	return header.KeyID, nil
+}
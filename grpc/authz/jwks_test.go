@@ -0,0 +1,306 @@
+package authz
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/cristalhq/jwt/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const protectedMethod = "/mwitkow.testproto.TestService/Ping"
+
+// methodStream lets AuthFunc be called directly, outside of a grpc server
+type methodStream struct {
+	grpc.ServerTransportStream
+	method string
+}
+
+func (m methodStream) Method() string { return m.method }
+
+func authCtx(method, token string) context.Context {
+	ctx := grpc.NewContextWithServerTransportStream(context.Background(), methodStream{method: method})
+	return ctxWithTokenIncoming(ctx, "bearer", token)
+}
+
+// createTokenKID builds a token with a kid header, which jwt.Builder can't do
+func createTokenKID(t *testing.T, signer jwt.Signer, kid string, claims interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": signer.Algorithm().String(), "typ": "JWT", "kid": kid})
+	require.NoError(t, err)
+	body, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	signature, err := signer.Sign([]byte(payload))
+	require.NoError(t, err)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func jwksDoc(t *testing.T, keys ...map[string]string) []byte {
+	doc, err := json.Marshal(map[string]interface{}{"keys": keys})
+	require.NoError(t, err)
+	return doc
+}
+
+type rsaSigner struct {
+	jwt.Signer
+	key *rsa.PrivateKey
+}
+
+func newRSASigner(t *testing.T) rsaSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signer, err := jwt.NewSignerRS(jwt.RS256, key)
+	require.NoError(t, err)
+	return rsaSigner{Signer: signer, key: key}
+}
+
+// identityProvider is a local stand-in for an identity provider's jwks_uri
+type identityProvider struct {
+	*httptest.Server
+	fetches int32
+
+	mu     sync.Mutex
+	doc    []byte
+	status int
+}
+
+func newIdentityProvider(t *testing.T) *identityProvider {
+	idp := &identityProvider{status: http.StatusOK}
+	idp.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&idp.fetches, 1)
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		w.WriteHeader(idp.status)
+		_, _ = w.Write(idp.doc)
+	}))
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *identityProvider) serve(status int, doc []byte) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.status = status
+	idp.doc = doc
+}
+
+func (idp *identityProvider) fetched() int {
+	return int(atomic.LoadInt32(&idp.fetches))
+}
+
+func TestJWKSFromURL(t *testing.T) {
+	key1, key2 := newRSASigner(t), newRSASigner(t)
+	claims := userClaims{StandardClaims: jwt.StandardClaims{Audience: []string{"admin"}}}
+
+	idp := newIdentityProvider(t)
+	idp.serve(http.StatusOK, jwksDoc(t, rsaJWK("k1", &key1.key.PublicKey)))
+
+	backoff := 100 * time.Millisecond
+	config := NewConfig(jwt.RS256, map[string][]string{protectedMethod: {}},
+		WithAudience("admin"),
+		WithJWKS(NewJWKSFromURL(idp.URL, WithJWKSBackoff(backoff, time.Second))),
+	)
+	auth := func(signer jwt.Signer, kid string) codes.Code {
+		_, err := config.AuthFunc(authCtx(protectedMethod, createTokenKID(t, signer, kid, claims)))
+		return status.Code(err)
+	}
+
+	// keys are fetched once and cached
+	assert.Equal(t, codes.OK, auth(key1, "k1"))
+	assert.Equal(t, codes.OK, auth(key1, "k1"))
+	assert.Equal(t, 1, idp.fetched())
+
+	// the key must match the kid
+	assert.Equal(t, codes.Unauthenticated, auth(key2, "k1"))
+
+	// rotating in a new key is picked up by the first token using it
+	idp.serve(http.StatusOK, jwksDoc(t, rsaJWK("k1", &key1.key.PublicKey), rsaJWK("k2", &key2.key.PublicKey)))
+	assert.Equal(t, codes.OK, auth(key2, "k2"))
+	assert.Equal(t, 2, idp.fetched())
+
+	// unknown kids back off before refreshing again
+	assert.Equal(t, codes.Unauthenticated, auth(key2, "k3"))
+	assert.Equal(t, 3, idp.fetched())
+	assert.Equal(t, codes.Unauthenticated, auth(key2, "k3"))
+	assert.Equal(t, 3, idp.fetched())
+	assert.Equal(t, codes.OK, auth(key1, "k1"), "known keys are still served while backing off")
+	time.Sleep(backoff + 50*time.Millisecond)
+	assert.Equal(t, codes.Unauthenticated, auth(key2, "k3"))
+	assert.Equal(t, 4, idp.fetched())
+}
+
+func TestJWKSTTL(t *testing.T) {
+	key := newRSASigner(t)
+	claims := userClaims{StandardClaims: jwt.StandardClaims{Audience: []string{"admin"}}}
+
+	idp := newIdentityProvider(t)
+	idp.serve(http.StatusOK, jwksDoc(t, rsaJWK("k1", &key.key.PublicKey)))
+
+	ttl := 100 * time.Millisecond
+	config := NewConfig(jwt.RS256, map[string][]string{protectedMethod: {}},
+		WithAudience("admin"),
+		WithJWKS(NewJWKSFromURL(idp.URL, WithJWKSTTL(ttl), WithJWKSBackoff(time.Minute, time.Minute))),
+	)
+	auth := func() codes.Code {
+		_, err := config.AuthFunc(authCtx(protectedMethod, createTokenKID(t, key, "k1", claims)))
+		return status.Code(err)
+	}
+
+	assert.Equal(t, codes.OK, auth())
+	time.Sleep(ttl + 50*time.Millisecond)
+	assert.Equal(t, codes.OK, auth())
+	assert.Equal(t, 2, idp.fetched())
+
+	// a failed refresh keeps serving the stale keys
+	idp.serve(http.StatusInternalServerError, nil)
+	time.Sleep(ttl + 50*time.Millisecond)
+	assert.Equal(t, codes.OK, auth())
+	assert.Equal(t, 3, idp.fetched())
+}
+
+func TestJWKSUnavailable(t *testing.T) {
+	key := newRSASigner(t)
+	claims := userClaims{StandardClaims: jwt.StandardClaims{Audience: []string{"admin"}}}
+
+	idp := newIdentityProvider(t)
+	idp.serve(http.StatusInternalServerError, nil)
+
+	config := NewConfig(jwt.RS256, map[string][]string{protectedMethod: {}},
+		WithAudience("admin"),
+		WithJWKS(NewJWKSFromURL(idp.URL)),
+	)
+	_, err := config.AuthFunc(authCtx(protectedMethod, createTokenKID(t, key, "k1", claims)))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+
+	_, err = config.AuthFunc(authCtx(protectedMethod, "bad_token"))
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestJWKSFromFile(t *testing.T) {
+	key1, key2 := newRSASigner(t), newRSASigner(t)
+	claims := userClaims{StandardClaims: jwt.StandardClaims{Audience: []string{"admin"}}}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, ioutil.WriteFile(path, jwksDoc(t, rsaJWK("k1", &key1.key.PublicKey)), 0600))
+
+	config := NewConfig(jwt.RS256, map[string][]string{protectedMethod: {}},
+		WithAudience("admin"),
+		WithJWKS(NewJWKSFromFile(path, WithJWKSBackoff(10*time.Millisecond, 10*time.Millisecond))),
+	)
+	auth := func(signer jwt.Signer, kid string) codes.Code {
+		_, err := config.AuthFunc(authCtx(protectedMethod, createTokenKID(t, signer, kid, claims)))
+		return status.Code(err)
+	}
+
+	assert.Equal(t, codes.OK, auth(key1, "k1"))
+	assert.Equal(t, codes.Unauthenticated, auth(key2, "k2"))
+
+	require.NoError(t, ioutil.WriteFile(path, jwksDoc(t, rsaJWK("k2", &key2.key.PublicKey)), 0600))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, codes.OK, auth(key2, "k2"))
+}
+
+func TestParseJWKS(t *testing.T) {
+	key := newRSASigner(t)
+	good := rsaJWK("k1", &key.key.PublicKey)
+	encryption := rsaJWK("enc", &key.key.PublicKey)
+	encryption["use"] = "enc"
+
+	keys, err := parseJWKS(jwksDoc(t, good, encryption, map[string]string{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"}))
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+	assert.Equal(t, &key.key.PublicKey, keys["k1"])
+
+	_, err = parseJWKS([]byte("not json"))
+	assert.Error(t, err)
+
+	bad := rsaJWK("bad", &key.key.PublicKey)
+	bad["n"] = "not base64!"
+	_, err = parseJWKS(jwksDoc(t, bad))
+	assert.Error(t, err)
+
+	bad = rsaJWK("bad", &key.key.PublicKey)
+	bad["e"] = ""
+	_, err = parseJWKS(jwksDoc(t, bad))
+	assert.Error(t, err)
+}
+
+func TestJWKSCallerCancelled(t *testing.T) {
+	key := newRSASigner(t)
+	claims := userClaims{StandardClaims: jwt.StandardClaims{Audience: []string{"admin"}}}
+	doc := jwksDoc(t, rsaJWK("k1", &key.key.PublicKey))
+
+	release := make(chan struct{})
+	var fetches int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			<-release
+		}
+		_, _ = w.Write(doc)
+	}))
+	t.Cleanup(idp.Close)
+
+	config := NewConfig(jwt.RS256, map[string][]string{protectedMethod: {}},
+		WithAudience("admin"),
+		WithJWKS(NewJWKSFromURL(idp.URL, WithJWKSBackoff(time.Minute, time.Minute))),
+	)
+	token := createTokenKID(t, key, "k1", claims)
+
+	// a caller giving up doesn't abort the shared fetch, or make the next caller back off
+	ctx, cancel := context.WithCancel(authCtx(protectedMethod, token))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err := config.AuthFunc(ctx)
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	close(release)
+	_, err = config.AuthFunc(authCtx(protectedMethod, token))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+}
+
+func TestJWKSTimeout(t *testing.T) {
+	key := newRSASigner(t)
+	claims := userClaims{StandardClaims: jwt.StandardClaims{Audience: []string{"admin"}}}
+
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(idp.Close)
+
+	config := NewConfig(jwt.RS256, map[string][]string{protectedMethod: {}},
+		WithAudience("admin"),
+		WithJWKS(NewJWKSFromURL(idp.URL, WithJWKSTimeout(20*time.Millisecond))),
+	)
+	_, err := config.AuthFunc(authCtx(protectedMethod, createTokenKID(t, key, "k1", claims)))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,69 @@
+package authz
+
+import (
+	"testing"
+	"time"
+
+	jwt "github.com/cristalhq/jwt/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClaimValidation(t *testing.T) {
+	signer, err := jwt.NewSignerHS(jwt.HS256, hsKey)
+	require.NoError(t, err)
+
+	now := time.Now()
+	at := func(d time.Duration) *jwt.NumericDate { return jwt.NewNumericDate(now.Add(d)) }
+	claims := func(modify func(*jwt.StandardClaims)) jwt.StandardClaims {
+		c := jwt.StandardClaims{Audience: []string{"admin"}}
+		if modify != nil {
+			modify(&c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		opts   []ConfigOption
+		claims jwt.StandardClaims
+		code   codes.Code
+	}{
+		{name: "valid", claims: claims(nil)},
+		{name: "expired", claims: claims(func(c *jwt.StandardClaims) { c.ExpiresAt = at(-5 * time.Second) }), code: codes.Unauthenticated},
+		{name: "expired within leeway", opts: []ConfigOption{WithLeeway(10 * time.Second)}, claims: claims(func(c *jwt.StandardClaims) { c.ExpiresAt = at(-5 * time.Second) })},
+		{name: "expired past leeway", opts: []ConfigOption{WithLeeway(time.Second)}, claims: claims(func(c *jwt.StandardClaims) { c.ExpiresAt = at(-5 * time.Second) }), code: codes.Unauthenticated},
+		{name: "not before", claims: claims(func(c *jwt.StandardClaims) { c.NotBefore = at(5 * time.Second) }), code: codes.Unauthenticated},
+		{name: "not before within leeway", opts: []ConfigOption{WithLeeway(10 * time.Second)}, claims: claims(func(c *jwt.StandardClaims) { c.NotBefore = at(5 * time.Second) })},
+		{name: "issued in the future", claims: claims(func(c *jwt.StandardClaims) { c.IssuedAt = at(5 * time.Second) }), code: codes.Unauthenticated},
+		{name: "issued in the future within leeway", opts: []ConfigOption{WithLeeway(10 * time.Second)}, claims: claims(func(c *jwt.StandardClaims) { c.IssuedAt = at(5 * time.Second) })},
+
+		{name: "issuer", opts: []ConfigOption{WithIssuer("https://idp.example.com")}, claims: claims(func(c *jwt.StandardClaims) { c.Issuer = "https://idp.example.com" })},
+		{name: "wrong issuer", opts: []ConfigOption{WithIssuer("https://idp.example.com")}, claims: claims(func(c *jwt.StandardClaims) { c.Issuer = "https://staging.example.com" }), code: codes.Unauthenticated},
+		{name: "missing issuer", opts: []ConfigOption{WithIssuer("https://idp.example.com")}, claims: claims(nil), code: codes.Unauthenticated},
+
+		{name: "required claims", opts: []ConfigOption{WithRequiredClaims("exp", "sub")}, claims: claims(func(c *jwt.StandardClaims) { c.ExpiresAt = at(time.Minute); c.Subject = "user" })},
+		{name: "missing required claim", opts: []ConfigOption{WithRequiredClaims("exp"), WithRequiredClaims("sub")}, claims: claims(func(c *jwt.StandardClaims) { c.Subject = "user" }), code: codes.Unauthenticated},
+
+		{name: "max age", opts: []ConfigOption{WithMaxTokenAge(time.Minute)}, claims: claims(func(c *jwt.StandardClaims) { c.IssuedAt = at(-30 * time.Second) })},
+		{name: "too old", opts: []ConfigOption{WithMaxTokenAge(time.Minute)}, claims: claims(func(c *jwt.StandardClaims) { c.IssuedAt = at(-2 * time.Minute) }), code: codes.Unauthenticated},
+		{name: "too old within leeway", opts: []ConfigOption{WithMaxTokenAge(time.Minute), WithLeeway(10 * time.Second)}, claims: claims(func(c *jwt.StandardClaims) { c.IssuedAt = at(-65 * time.Second) })},
+		{name: "max age without iat", opts: []ConfigOption{WithMaxTokenAge(time.Minute)}, claims: claims(nil), code: codes.Unauthenticated},
+
+		{name: "other audience", opts: []ConfigOption{WithAudiences("staging", "production")}, claims: claims(func(c *jwt.StandardClaims) { c.Audience = []string{"production"} })},
+		{name: "one of several audiences", opts: []ConfigOption{WithAudiences("staging")}, claims: claims(func(c *jwt.StandardClaims) { c.Audience = []string{"dev", "staging"} })},
+		{name: "unknown audience", opts: []ConfigOption{WithAudiences("staging", "production")}, claims: claims(func(c *jwt.StandardClaims) { c.Audience = []string{"dev"} }), code: codes.Unauthenticated},
+		{name: "audience validation disabled", opts: []ConfigOption{WithDisableAudienceValidation(true)}, claims: claims(func(c *jwt.StandardClaims) { c.Audience = []string{"dev"} })},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := append([]ConfigOption{WithHSKey(hsKey), WithAudience("admin")}, test.opts...)
+			config := NewConfig(jwt.HS256, map[string][]string{protectedMethod: {}}, opts...)
+
+			_, err := config.AuthFunc(authCtx(protectedMethod, createTokenKID(t, signer, "", test.claims)))
+			assert.Equal(t, test.code, status.Code(err), "%v", err)
+		})
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/packethost/pkg/env"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+)
+
+// dialer is used to hold configured info for Dial
+type dialer struct {
+	err        error
+	options    []grpc.DialOption
+	streamers  []grpc.StreamClientInterceptor
+	unariers   []grpc.UnaryClientInterceptor
+	secure     bool
+	rootCAs    *x509.CertPool
+	cert       tls.Certificate
+	serverName string
+	keepalive  keepalive.ClientParameters
+	retry      []grpc_retry.CallOption
+}
+
+// The DialOption type describes functions that operate on the client connection setup during Dial.
+type DialOption func(*dialer)
+
+// Dial creates a client connection to target, mirroring the defaults of NewServer.
+// By default the connection is insecure, with logging, prometheus, keepalive and retry interceptors setup.
+//
+// A tls connection is setup if the server's CA is provided in either the environment variable GRPC_CLIENT_ROOT_CA, or using the DialRootCAs helper func.
+// Setting GRPC_CLIENT_TLS to a true value, or using the DialTLS helper func, enables tls verified against the system's roots.
+// A client certificate (mTLS) is presented if keys are provided in either the environment variables GRPC_CLIENT_CERT/GRPC_CLIENT_KEY, or using the DialX509KeyPair or DialLoadX509KeyPair helper funcs.
+// Logging is always setup using the provided log.Logger.
+// The request ID of the calling context, see RequestIDFromContext, is always forwarded in the x-request-id metadata.
+// Prometheus is always setup using the default client prom interceptors.
+// OpenTelemetry is setup for unary calls, but NOT streaming calls. Use DialStreamInterceptor to add it if you really want/need it.
+// Unary calls are retried up to 3 times with exponential backoff when the server is UNAVAILABLE, see DialRetry.
+func Dial(ctx context.Context, target string, l log.Logger, options ...DialOption) (*grpc.ClientConn, error) {
+	d := &dialer{
+		keepalive: keepalive.ClientParameters{
+			Time:    5 * time.Minute,
+			Timeout: 20 * time.Second,
+		},
+		retry: []grpc_retry.CallOption{
+			grpc_retry.WithMax(3),
+			grpc_retry.WithBackoff(grpc_retry.BackoffExponentialWithJitter(100*time.Millisecond, 0.2)),
+			grpc_retry.WithCodes(codes.Unavailable),
+		},
+	}
+
+	for _, opt := range options {
+		opt(d)
+		if d.err != nil {
+			return nil, d.err
+		}
+	}
+
+	// the defaults are prepended once the options are known since the retry interceptor is built from them
+	logStream, logUnary := l.GRPCClientLoggers()
+	d.streamers = append([]grpc.StreamClientInterceptor{
+		logStream,
+		requestIDStreamClientInterceptor,
+		grpc_prometheus.StreamClientInterceptor,
+	}, d.streamers...)
+	d.unariers = append([]grpc.UnaryClientInterceptor{
+		logUnary,
+		requestIDUnaryClientInterceptor,
+		grpc_retry.UnaryClientInterceptor(d.retry...),
+		grpc_prometheus.UnaryClientInterceptor,
+		otelgrpc.UnaryClientInterceptor(),
+	}, d.unariers...)
+
+	creds, err := dialCredentials(d)
+	if err != nil {
+		return nil, err
+	}
+
+	d.options = append(d.options,
+		creds,
+		grpc.WithKeepaliveParams(d.keepalive),
+		grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(d.streamers...)),
+		grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(d.unariers...)),
+	)
+
+	conn, err := grpc.DialContext(ctx, target, d.options...)
+	if err != nil {
+		return nil, errors.Wrap(err, "dial")
+	}
+	return conn, nil
+}
+
+// dialCredentials picks up the tls info from the environment, unless the user has specified it via the Dial* helper funcs, and returns the matching transport credentials
+func dialCredentials(d *dialer) (grpc.DialOption, error) {
+	if d.cert.PrivateKey == nil {
+		cert := env.Get("GRPC_CLIENT_CERT")
+		key := env.Get("GRPC_CLIENT_KEY")
+
+		if cert != "" && key != "" {
+			kp, err := tls.X509KeyPair([]byte(cert), []byte(key))
+			if err != nil {
+				return nil, errors.Wrap(err, "parse client tls keys from env")
+			}
+			d.cert = kp
+			d.secure = true
+		}
+	}
+
+	if d.rootCAs == nil {
+		if ca := env.Get("GRPC_CLIENT_ROOT_CA"); ca != "" {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(ca)) {
+				return nil, errors.New("parse root ca from env: no certificates found")
+			}
+			d.rootCAs = pool
+			d.secure = true
+		}
+	}
+
+	if env.Bool("GRPC_CLIENT_TLS") {
+		d.secure = true
+	}
+
+	if !d.secure {
+		return grpc.WithInsecure(), nil
+	}
+
+	config := &tls.Config{
+		RootCAs:    d.rootCAs,
+		ServerName: d.serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if d.cert.PrivateKey != nil {
+		config.Certificates = []tls.Certificate{d.cert}
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(config)), nil
+}
+
+// DialGRPCOption will add the opt param to the underlying grpc.DialContext() call.
+func DialGRPCOption(opt grpc.DialOption) DialOption {
+	return func(d *dialer) {
+		d.options = append(d.options, opt)
+	}
+}
+
+// DialTLS will setup the connection to use tls, verifying the server's certificate against the system's roots unless DialRootCAs is also used.
+func DialTLS() DialOption {
+	return func(d *dialer) {
+		d.secure = true
+	}
+}
+
+// DialRootCAs will setup the connection to use tls, verifying the server's certificate against the provided PEM encoded CA certificates.
+// This function overrides the GRPC_CLIENT_ROOT_CA environment variable.
+func DialRootCAs(caPEMBlock string) DialOption {
+	return func(d *dialer) {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(caPEMBlock)) {
+			d.err = errors.New("parse root CAs: no certificates found")
+			return
+		}
+		d.rootCAs = pool
+		d.secure = true
+	}
+}
+
+// DialServerName overrides the server name used to verify the server's certificate, by default it is derived from the target.
+func DialServerName(name string) DialOption {
+	return func(d *dialer) {
+		d.serverName = name
+	}
+}
+
+// DialX509KeyPair will setup the connection to use tls and present the provided client cert and key (mTLS).
+// This function overrides GRPC_CLIENT_CERT and GRPC_CLIENT_KEY environment variables.
+// Dial will return an error if both DialX509KeyPair and DialLoadX509KeyPair are used.
+func DialX509KeyPair(certPEMBlock, keyPEMBlock string) DialOption {
+	return func(d *dialer) {
+		if d.cert.PrivateKey != nil {
+			d.err = errors.New("client certificate is already set")
+			return
+		}
+
+		var err error
+		d.cert, err = tls.X509KeyPair([]byte(certPEMBlock), []byte(keyPEMBlock))
+		if err != nil {
+			d.err = errors.Wrap(err, "parse x509 key pair")
+			return
+		}
+		d.secure = true
+	}
+}
+
+// DialLoadX509KeyPair will setup the connection to use tls and present the client cert and key read from the provided file locations (mTLS).
+// This function overrides GRPC_CLIENT_CERT and GRPC_CLIENT_KEY environment variables.
+// Dial will return an error if both DialX509KeyPair and DialLoadX509KeyPair are used.
+func DialLoadX509KeyPair(certFile, keyFile string) DialOption {
+	return func(d *dialer) {
+		if d.cert.PrivateKey != nil {
+			d.err = errors.New("client certificate is already set")
+			return
+		}
+
+		var err error
+		d.cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			d.err = errors.Wrap(err, "load x509 key pair")
+			return
+		}
+		d.secure = true
+	}
+}
+
+// DialKeepalive overrides the default keepalive parameters of 5m between pings and a 20s ping timeout.
+// Pinging more often than the server's enforcement policy allows (5m by default) will get the connection closed by the server.
+func DialKeepalive(params keepalive.ClientParameters) DialOption {
+	return func(d *dialer) {
+		d.keepalive = params
+	}
+}
+
+// DialRetry replaces the default retry policy for unary calls with the provided grpc_retry options.
+// Use grpc_retry.Disable() to turn retries off.
+func DialRetry(opts ...grpc_retry.CallOption) DialOption {
+	return func(d *dialer) {
+		d.retry = opts
+	}
+}
+
+// DialStreamInterceptor adds the argument to the list of interceptors in a grpc_middleware.Chain
+// Logging and Prometheus interceptors are always included in the set. OpenTelemetry is NOT
+// included by default on streams because it's noisy and can't be on by default.
+func DialStreamInterceptor(si grpc.StreamClientInterceptor) DialOption {
+	return func(d *dialer) {
+		d.streamers = append(d.streamers, si)
+	}
+}
+
+// DialUnaryInterceptor adds the argument to the list of interceptors in a grpc_middleware.Chain
+// Logging, retry, Prometheus, and OpenTelemetry interceptors are always included in the set
+func DialUnaryInterceptor(ui grpc.UnaryClientInterceptor) DialOption {
+	return func(d *dialer) {
+		d.unariers = append(d.unariers, ui)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	grpc_retry "github.com/grpc-ecosystem/go-grpc-middleware/retry"
+	"github.com/packethost/pkg/internal/testenv"
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+)
+
+// flakyServer is a helloworld.GreeterServer that fails with UNAVAILABLE for the first failures calls
+type flakyServer struct {
+	pb.UnimplementedGreeterServer
+	failures int32
+	calls    int32
+}
+
+func (f *flakyServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	if atomic.AddInt32(&f.calls, 1) <= f.failures {
+		return nil, status.Error(codes.Unavailable, "try again")
+	}
+	return &pb.HelloReply{Message: "Hello " + in.Name}, nil
+}
+
+func dialHello(t *testing.T, port int, opts ...DialOption) (string, error) {
+	conn, err := Dial(context.Background(), fmt.Sprintf("localhost:%d", port), log.Test(t, svc), opts...)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	reply, err := pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: "client"})
+	if err != nil {
+		return "", err
+	}
+	return reply.Message, nil
+}
+
+func TestDial(t *testing.T) {
+	defer testenv.Clear().Restore()
+
+	cert, key := genCert(t)
+	clientCert, clientKey := genClientCert(t, "client-1")
+	clientCertF, clientKeyF := writeTLSFiles(t, clientCert, clientKey)
+	defer os.Remove(clientCertF)
+	defer os.Remove(clientKeyF)
+
+	t.Run("insecure", func(t *testing.T) {
+		assert := require.New(t)
+
+		var called int32
+		interceptor := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			atomic.AddInt32(&called, 1)
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		s, err := NewServer(log.Test(t, svc), defSrv)
+		assert.NoError(err)
+		serve(t, s, func() {
+			msg, err := dialHello(t, s.Port(), DialUnaryInterceptor(interceptor))
+			assert.NoError(err)
+			assert.Equal("Hello client", msg)
+			assert.EqualValues(1, atomic.LoadInt32(&called))
+
+			_, err = dialHello(t, s.Port(), DialRootCAs(cert))
+			assert.Error(err)
+		})
+	})
+	t.Run("tls", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), defSrv, X509KeyPair(cert, key))
+		assert.NoError(err)
+		serve(t, s, func() {
+			msg, err := dialHello(t, s.Port(), DialRootCAs(cert), DialServerName("localhost"))
+			assert.NoError(err)
+			assert.Equal("Hello client", msg)
+
+			_, err = dialHello(t, s.Port())
+			assert.Error(err)
+			_, err = dialHello(t, s.Port(), DialTLS())
+			assert.Error(err)
+		})
+	})
+	t.Run("mtls", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), identitySrv, X509KeyPair(cert, key), ClientCAs(clientCert))
+		assert.NoError(err)
+		serve(t, s, func() {
+			_, err := dialHello(t, s.Port(), DialRootCAs(cert))
+			assert.Error(err)
+
+			msg, err := dialHello(t, s.Port(), DialRootCAs(cert), DialX509KeyPair(clientCert, clientKey))
+			assert.NoError(err)
+			assert.Equal("Hello client-1", msg)
+
+			msg, err = dialHello(t, s.Port(), DialRootCAs(cert), DialLoadX509KeyPair(clientCertF, clientKeyF))
+			assert.NoError(err)
+			assert.Equal("Hello client-1", msg)
+		})
+	})
+	t.Run("env", func(t *testing.T) {
+		defer testenv.Clear().Restore()
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), identitySrv, X509KeyPair(cert, key), ClientCAs(clientCert))
+		assert.NoError(err)
+
+		os.Setenv("GRPC_CLIENT_ROOT_CA", cert)
+		os.Setenv("GRPC_CLIENT_CERT", clientCert)
+		os.Setenv("GRPC_CLIENT_KEY", clientKey)
+		serve(t, s, func() {
+			msg, err := dialHello(t, s.Port())
+			assert.NoError(err)
+			assert.Equal("Hello client-1", msg)
+		})
+	})
+	t.Run("retry", func(t *testing.T) {
+		assert := require.New(t)
+
+		f := &flakyServer{failures: 2}
+		s, err := NewServer(log.Test(t, svc), func(s *Server) { pb.RegisterGreeterServer(s.Server(), f) })
+		assert.NoError(err)
+		serve(t, s, func() {
+			msg, err := dialHello(t, s.Port())
+			assert.NoError(err)
+			assert.Equal("Hello client", msg)
+			assert.EqualValues(3, atomic.LoadInt32(&f.calls))
+
+			atomic.StoreInt32(&f.calls, 0)
+			_, err = dialHello(t, s.Port(), DialRetry(grpc_retry.Disable()))
+			assert.Equal(codes.Unavailable, status.Code(err))
+			assert.EqualValues(1, atomic.LoadInt32(&f.calls))
+		})
+	})
+	t.Run("assert-fail", func(t *testing.T) {
+		tests := map[string][]DialOption{
+			"invalid root CA":   {DialRootCAs("not a cert")},
+			"both key pairs":    {DialX509KeyPair(clientCert, clientKey), DialLoadX509KeyPair(clientCertF, clientKeyF)},
+			"invalid key pair":  {DialX509KeyPair(clientKey, clientCert)},
+			"missing key files": {DialLoadX509KeyPair(clientCertF+".missing", clientKeyF)},
+		}
+		for name, opts := range tests {
+			t.Run(name, func(t *testing.T) {
+				conn, err := Dial(context.Background(), "localhost:0", log.Test(t, svc), opts...)
+				require.Error(t, err)
+				require.Nil(t, conn)
+			})
+		}
+
+		defer testenv.Clear().Restore()
+		os.Setenv("GRPC_CLIENT_ROOT_CA", "not a cert")
+		conn, err := Dial(context.Background(), "localhost:0", log.Test(t, svc))
+		require.Error(t, err)
+		require.Nil(t, conn)
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// deadlines applies default, per method and maximum deadlines to incoming RPCs
+type deadlines struct {
+	def     time.Duration
+	methods map[string]time.Duration
+	max     time.Duration
+}
+
+func (s *Server) deadlineConfig() *deadlines {
+	if s.deadlines == nil {
+		s.deadlines = &deadlines{methods: map[string]time.Duration{}}
+	}
+	return s.deadlines
+}
+
+// DefaultTimeout sets the deadline applied to RPCs whose client did not send one.
+// RPCs that run past their deadline are logged with grpc.deadline_exceeded=true and counted in grpc_server_deadline_exceeded_total.
+func DefaultTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		if d <= 0 {
+			s.err = errors.New("default timeout must be > 0")
+			return
+		}
+
+		s.deadlineConfig().def = d
+	}
+}
+
+// MethodTimeout sets the deadline applied to calls of fullMethod, e.g. /helloworld.Greeter/SayHello, whose client did not send one, overriding DefaultTimeout.
+func MethodTimeout(fullMethod string, d time.Duration) Option {
+	return func(s *Server) {
+		if d <= 0 {
+			s.err = errors.New("method timeout must be > 0")
+			return
+		}
+
+		s.deadlineConfig().methods[fullMethod] = d
+	}
+}
+
+// MaxTimeout caps the deadline of every RPC, including those sent by clients, to d.
+func MaxTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		if d <= 0 {
+			s.err = errors.New("max timeout must be > 0")
+			return
+		}
+
+		s.deadlineConfig().max = d
+	}
+}
+
+// apply returns ctx with the configured deadline for method, the returned cancel func must always be called
+func (d *deadlines) apply(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	timeout, ok := d.methods[method]
+	if !ok {
+		timeout = d.def
+	}
+
+	deadline, ok := ctx.Deadline()
+	switch {
+	case !ok && timeout > 0:
+		if d.max > 0 && timeout > d.max {
+			timeout = d.max
+		}
+		return context.WithTimeout(ctx, timeout)
+	case d.max > 0 && (!ok || time.Until(deadline) > d.max):
+		return context.WithTimeout(ctx, d.max)
+	}
+	return context.WithCancel(ctx)
+}
+
+// exceeded records RPCs that ran past their deadline and makes sure they fail with codes.DeadlineExceeded
+func (s *Server) exceeded(ctx context.Context, method string, err error) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+
+	log.AddGRPCFields(ctx, "grpc.deadline_exceeded", true)
+	s.metrics.deadlineExceeded.WithLabelValues(method).Inc()
+
+	if _, ok := status.FromError(err); ok && err != nil {
+		return err
+	}
+	return status.FromContextError(ctx.Err()).Err()
+}
+
+func (s *Server) deadlineUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if s.deadlines == nil {
+		return handler(ctx, req)
+	}
+
+	ctx, cancel := s.deadlines.apply(ctx, info.FullMethod)
+	defer cancel()
+
+	resp, err := handler(ctx, req)
+	return resp, s.exceeded(ctx, info.FullMethod, err)
+}
+
+func (s *Server) deadlineStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if s.deadlines == nil {
+		return handler(srv, ss)
+	}
+
+	ctx, cancel := s.deadlines.apply(ss.Context(), info.FullMethod)
+	defer cancel()
+
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = ctx
+	return s.exceeded(ctx, info.FullMethod, handler(srv, wrapped))
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"github.com/packethost/pkg/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+)
+
+// deadlineServer is a helloworld.GreeterServer that replies with the time left until its deadline, or blocks until the deadline if asked to
+type deadlineServer struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (d *deadlineServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	if in.Name == "block" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return &pb.HelloReply{Message: "none"}, nil
+	}
+	return &pb.HelloReply{Message: time.Until(deadline).Round(time.Second).String()}, nil
+}
+
+func TestDeadlines(t *testing.T) {
+	l := log.Test(t, svc)
+	assert := require.New(t)
+
+	fails := map[string][]Option{
+		"default":  {DefaultTimeout(0)},
+		"method":   {MethodTimeout(sayHelloMethod, -time.Second)},
+		"max":      {MaxTimeout(0)},
+		"multiple": {DefaultTimeout(time.Second), MaxTimeout(-1)},
+	}
+	for name, opts := range fails {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewServer(l, defSrv, opts...)
+			require.Error(t, err)
+		})
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		timeout time.Duration
+		want    string
+	}{
+		{name: "unconfigured", want: "none"},
+		{name: "unconfigured client deadline", timeout: time.Minute, want: "1m0s"},
+		{name: "default", opts: []Option{DefaultTimeout(time.Minute)}, want: "1m0s"},
+		{name: "default keeps client deadline", opts: []Option{DefaultTimeout(time.Minute)}, timeout: time.Hour, want: "1h0m0s"},
+		{name: "method", opts: []Option{DefaultTimeout(time.Minute), MethodTimeout(sayHelloMethod, 10*time.Second)}, want: "10s"},
+		{name: "other method", opts: []Option{DefaultTimeout(time.Minute), MethodTimeout("/foo.Bar/Baz", 10*time.Second)}, want: "1m0s"},
+		{name: "max caps client deadline", opts: []Option{MaxTimeout(time.Minute)}, timeout: time.Hour, want: "1m0s"},
+		{name: "max keeps shorter client deadline", opts: []Option{MaxTimeout(time.Minute)}, timeout: 10 * time.Second, want: "10s"},
+		{name: "max caps default", opts: []Option{DefaultTimeout(time.Hour), MaxTimeout(time.Minute)}, want: "1m0s"},
+		{name: "max without client deadline", opts: []Option{MaxTimeout(time.Minute)}, want: "1m0s"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			s, err := NewServer(l, func(s *Server) { pb.RegisterGreeterServer(s.Server(), &deadlineServer{}) }, test.opts...)
+			assert.NoError(err)
+
+			serve(t, s, func() {
+				conn := dialInsecure(t, s.Port())
+				defer conn.Close()
+
+				ctx := context.Background()
+				if test.timeout > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, test.timeout)
+					defer cancel()
+				}
+				r, err := pb.NewGreeterClient(conn).SayHello(ctx, &pb.HelloRequest{Name: "deadline"})
+				assert.NoError(err)
+				assert.Equal(test.want, r.Message)
+			})
+		})
+	}
+
+	t.Run("exceeded", func(t *testing.T) {
+		s, err := NewServer(l, func(s *Server) { pb.RegisterGreeterServer(s.Server(), &deadlineServer{}) }, DefaultTimeout(50*time.Millisecond))
+		assert.NoError(err)
+		counter := defaultMetrics.deadlineExceeded.WithLabelValues(sayHelloMethod)
+		before := testutil.ToFloat64(counter)
+
+		serve(t, s, func() {
+			conn := dialInsecure(t, s.Port())
+			defer conn.Close()
+
+			_, err := pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: "block"})
+			assert.Equal(codes.DeadlineExceeded, status.Code(err))
+		})
+		assert.Equal(before+1, testutil.ToFloat64(counter))
+	})
+}
+
+func TestDeadlineExceededLogged(t *testing.T) {
+	assert := require.New(t)
+
+	s, err := NewServer(log.Test(t, svc), defSrv, DefaultTimeout(10*time.Millisecond))
+	assert.NoError(err)
+
+	core, logs := observer.New(zap.NewAtomicLevelAt(zap.InfoLevel))
+	ctx := ctxzap.ToContext(context.Background(), zap.New(core))
+	info := &grpc.UnaryServerInfo{FullMethod: sayHelloMethod}
+
+	_, err = s.deadlineUnaryInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.Equal(codes.DeadlineExceeded, status.Code(err))
+	ctxzap.Extract(ctx).Info("finished call")
+
+	_, err = s.deadlineUnaryInterceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+	assert.Equal(codes.NotFound, status.Code(err))
+
+	entries := logs.TakeAll()
+	assert.Len(entries, 1)
+	assert.Equal(true, entries[0].ContextMap()["grpc.deadline_exceeded"])
+}
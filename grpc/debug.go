@@ -0,0 +1,51 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"github.com/packethost/pkg/env"
+	"google.golang.org/grpc"
+	channelz "google.golang.org/grpc/channelz/service"
+	"google.golang.org/grpc/reflection"
+)
+
+// Reflection will register the grpc server reflection service, making it possible to use tools like grpcurl without the proto files.
+// Reflection can also be enabled by setting the GRPC_REFLECTION env variable to a true value, see env.Bool.
+func Reflection() Option {
+	return func(s *Server) {
+		if s.reflection {
+			return
+		}
+
+		s.reflection = true
+		s.registry = append(s.registry, func(gs *grpc.Server) {
+			reflection.Register(gs)
+		})
+	}
+}
+
+// Channelz will register the grpc channelz service, exposing runtime info about the server's connections and sockets.
+// Channelz can also be enabled by setting the GRPC_CHANNELZ env variable to a true value, see env.Bool.
+func Channelz() Option {
+	return func(s *Server) {
+		if s.channelz {
+			return
+		}
+
+		s.channelz = true
+		s.registry = append(s.registry, func(gs *grpc.Server) {
+			channelz.RegisterChannelzServiceToServer(gs)
+		})
+	}
+}
+
+// maybeSetDebugFromEnv will enable the reflection and channelz services if requested in the environment, unless the user has already enabled them via `Reflection` or `Channelz`
+func maybeSetDebugFromEnv(s *Server) {
+	if env.Bool("GRPC_REFLECTION") {
+		Reflection()(s)
+	}
+	if env.Bool("GRPC_CHANNELZ") {
+		Channelz()(s)
+	}
+}
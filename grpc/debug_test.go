@@ -0,0 +1,58 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"os"
+	"testing"
+
+	"github.com/packethost/pkg/internal/testenv"
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	reflectionService = "grpc.reflection.v1alpha.ServerReflection"
+	channelzService   = "grpc.channelz.v1.Channelz"
+)
+
+func TestDebugServices(t *testing.T) {
+	defer testenv.Clear().Restore()
+
+	tests := []struct {
+		name       string
+		env        map[string]string
+		opts       []Option
+		reflection bool
+		channelz   bool
+	}{
+		{name: "defaults"},
+		{name: "reflection option", opts: []Option{Reflection()}, reflection: true},
+		{name: "channelz option", opts: []Option{Channelz()}, channelz: true},
+		{name: "both options twice", opts: []Option{Reflection(), Channelz(), Reflection(), Channelz()}, reflection: true, channelz: true},
+		{name: "env", env: map[string]string{"GRPC_REFLECTION": "true", "GRPC_CHANNELZ": "1"}, reflection: true, channelz: true},
+		{name: "env disabled", env: map[string]string{"GRPC_REFLECTION": "false", "GRPC_CHANNELZ": "no"}},
+		{name: "env and options", env: map[string]string{"GRPC_REFLECTION": "true", "GRPC_CHANNELZ": "1"}, opts: []Option{Reflection(), Channelz()}, reflection: true, channelz: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer testenv.Clear().Restore()
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+
+			l := log.Test(t, svc)
+			assert := require.New(t)
+
+			s, err := NewServer(l, defSrv, tt.opts...)
+			assert.NoError(err)
+
+			services := s.Server().GetServiceInfo()
+			_, ok := services[reflectionService]
+			assert.Equal(tt.reflection, ok)
+			_, ok = services[channelzService]
+			assert.Equal(tt.channelz, ok)
+		})
+	}
+}
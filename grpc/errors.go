@@ -0,0 +1,156 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Sentinel errors that handlers can return, usually wrapped with github.com/pkg/errors, to have the RPC fail with the matching status code.
+// Only the sentinel's message, e.g. "not found", is returned to the client, the whole error chain is logged.
+// Use NotFound and friends to return more to the client.
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrConflict        = errors.New("conflict")
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrUnauthenticated = errors.New("unauthenticated")
+)
+
+var sentinelCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{ErrNotFound, codes.NotFound},
+	{ErrConflict, codes.AlreadyExists},
+	{ErrInvalidArgument, codes.InvalidArgument},
+	{ErrUnauthenticated, codes.Unauthenticated},
+}
+
+// statusError is an error carrying the errdetails to send to the client, it matches its sentinel with errors.Is
+type statusError struct {
+	sentinel error
+	code     codes.Code
+	msg      string
+	details  []proto.Message
+}
+
+func (e *statusError) Error() string {
+	return e.msg + ": " + e.sentinel.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.sentinel
+}
+
+// GRPCStatus returns the status sent to the client, so e is also understood by status.FromError and status.Code
+func (e *statusError) GRPCStatus() *status.Status {
+	st := status.New(e.code, e.Error())
+	if detailed, err := st.WithDetails(e.details...); err == nil {
+		st = detailed
+	}
+	return st
+}
+
+// NotFound returns an error that fails the RPC with codes.NotFound and an errdetails.ResourceInfo detail describing the missing resource.
+func NotFound(resourceType, resourceName string) error {
+	return &statusError{
+		sentinel: ErrNotFound,
+		code:     codes.NotFound,
+		msg:      resourceType + " " + resourceName,
+		details:  []proto.Message{&errdetails.ResourceInfo{ResourceType: resourceType, ResourceName: resourceName}},
+	}
+}
+
+// Conflict returns an error that fails the RPC with codes.AlreadyExists and an errdetails.ResourceInfo detail describing the conflicting resource.
+func Conflict(resourceType, resourceName, description string) error {
+	return &statusError{
+		sentinel: ErrConflict,
+		code:     codes.AlreadyExists,
+		msg:      resourceType + " " + resourceName,
+		details:  []proto.Message{&errdetails.ResourceInfo{ResourceType: resourceType, ResourceName: resourceName, Description: description}},
+	}
+}
+
+// InvalidArgument returns an error that fails the RPC with codes.InvalidArgument and an errdetails.BadRequest detail describing the invalid field.
+func InvalidArgument(field, description string) error {
+	return &statusError{
+		sentinel: ErrInvalidArgument,
+		code:     codes.InvalidArgument,
+		msg:      field + " " + description,
+		details: []proto.Message{&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: field, Description: description}},
+		}},
+	}
+}
+
+// Unauthenticated returns an error that fails the RPC with codes.Unauthenticated and an errdetails.ErrorInfo detail with reason, e.g. TOKEN_EXPIRED.
+func Unauthenticated(reason string) error {
+	return &statusError{
+		sentinel: ErrUnauthenticated,
+		code:     codes.Unauthenticated,
+		msg:      reason,
+		details:  []proto.Message{&errdetails.ErrorInfo{Reason: reason}},
+	}
+}
+
+// toStatus maps err to the status returned to the client, ok is false if err is an internal error that must not be exposed
+func toStatus(err error) (st *status.Status, ok bool) {
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &grpcErr) {
+		return grpcErr.GRPCStatus(), true
+	}
+
+	for _, s := range sentinelCodes {
+		if errors.Is(err, s.err) {
+			return status.New(s.code, s.err.Error()), true
+		}
+	}
+
+	// the status is built here, rather than with status.FromContextError, so none of the wrapping messages are returned
+	if errors.Is(err, context.Canceled) {
+		return status.New(codes.Canceled, context.Canceled.Error()), true
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.New(codes.DeadlineExceeded, context.DeadlineExceeded.Error()), true
+	}
+	return nil, false
+}
+
+// errorInterceptors returns interceptors that turn errors returned by handlers into status errors.
+// Status errors, including wrapped ones, and the sentinel errors are mapped to their codes.
+// The wrapping messages aren't returned to the client, they are logged via l.Info instead.
+// Any other error is logged in full via l.Error, and so is also reported to rollbar, and returned to the client as codes.Internal without its message.
+func errorInterceptors(l log.Logger) (grpc.StreamServerInterceptor, grpc.UnaryServerInterceptor) {
+	convert := func(ctx context.Context, method string, err error) error {
+		if err == nil {
+			return nil
+		}
+		if st, ok := toStatus(err); ok {
+			if st.Message() != err.Error() {
+				requestIDLogger(ctx, l).With("grpc.method", method, "grpc.code", st.Code().String(), "error", err.Error()).Info("returning error to client")
+			}
+			return st.Err()
+		}
+
+		requestIDLogger(ctx, l).With("grpc.method", method).Error(err)
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	stream := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return convert(ss.Context(), info.FullMethod, handler(srv, ss))
+	}
+	unary := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		return resp, convert(ctx, info.FullMethod, err)
+	}
+	return stream, unary
+}
@@ -0,0 +1,172 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+)
+
+// recordingT records the lines logged by a log.Test logger
+type recordingT struct {
+	*testing.T
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *recordingT) Logf(format string, args ...interface{}) {
+	r.mu.Lock()
+	r.lines = append(r.lines, fmt.Sprintf(format, args...))
+	r.mu.Unlock()
+	r.T.Logf(format, args...)
+}
+
+func (r *recordingT) logged() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return strings.Join(r.lines, "\n")
+}
+
+// errorServer is a helloworld.GreeterServer that returns the error named in the request
+type errorServer struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (e *errorServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	var err error
+	switch in.Name {
+	case "not found":
+		err = errors.Wrap(ErrNotFound, "user 42")
+	case "conflict":
+		err = errors.Wrapf(ErrConflict, "user %s", "bob")
+	case "invalid":
+		err = errors.WithMessage(ErrInvalidArgument, "name is required")
+	case "unauthenticated":
+		err = ErrUnauthenticated
+	case "not found details":
+		err = errors.Wrap(NotFound("user", "42"), "loading owner")
+	case "conflict details":
+		err = Conflict("user", "bob", "username is taken")
+	case "invalid details":
+		err = InvalidArgument("name", "is required")
+	case "unauthenticated details":
+		err = Unauthenticated("TOKEN_EXPIRED")
+	case "status":
+		err = errors.Wrap(status.Error(codes.FailedPrecondition, "not ready"), "checking state")
+	case "canceled":
+		err = errors.Wrap(context.Canceled, "waiting for db")
+	case "canceled stdlib":
+		err = fmt.Errorf("query users at db-host:5432: %w", context.Canceled)
+	case "deadline mixed":
+		err = fmt.Errorf("lookup: %w", errors.Wrap(context.DeadlineExceeded, "db"))
+	case "internal":
+		err = errors.Wrap(errors.New("pq: password authentication failed for user \"admin\""), "connecting to db")
+	default:
+		return &pb.HelloReply{Message: "Hello " + in.Name}, nil
+	}
+	return nil, err
+}
+
+func TestErrors(t *testing.T) {
+	rt := &recordingT{T: t}
+	s, err := NewServer(log.Test(rt, svc), func(s *Server) { pb.RegisterGreeterServer(s.Server(), &errorServer{}) })
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		code    codes.Code
+		message string
+		detail  interface{}
+	}{
+		{name: "not found", code: codes.NotFound, message: "not found"},
+		{name: "conflict", code: codes.AlreadyExists, message: "conflict"},
+		{name: "invalid", code: codes.InvalidArgument, message: "invalid argument"},
+		{name: "unauthenticated", code: codes.Unauthenticated, message: "unauthenticated"},
+		{
+			name:    "not found details",
+			code:    codes.NotFound,
+			message: "user 42: not found",
+			detail:  &errdetails.ResourceInfo{ResourceType: "user", ResourceName: "42"},
+		},
+		{
+			name:    "conflict details",
+			code:    codes.AlreadyExists,
+			message: "user bob: conflict",
+			detail:  &errdetails.ResourceInfo{ResourceType: "user", ResourceName: "bob", Description: "username is taken"},
+		},
+		{
+			name:    "invalid details",
+			code:    codes.InvalidArgument,
+			message: "name is required: invalid argument",
+			detail: &errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "name", Description: "is required"},
+			}},
+		},
+		{
+			name:    "unauthenticated details",
+			code:    codes.Unauthenticated,
+			message: "TOKEN_EXPIRED: unauthenticated",
+			detail:  &errdetails.ErrorInfo{Reason: "TOKEN_EXPIRED"},
+		},
+		{name: "status", code: codes.FailedPrecondition, message: "not ready"},
+		{name: "canceled", code: codes.Canceled, message: context.Canceled.Error()},
+		{name: "canceled stdlib", code: codes.Canceled, message: context.Canceled.Error()},
+		{name: "deadline mixed", code: codes.DeadlineExceeded, message: context.DeadlineExceeded.Error()},
+		{name: "internal", code: codes.Internal, message: "internal error"},
+	}
+
+	serve(t, s, func() {
+		conn := dialInsecure(t, s.Port())
+		defer conn.Close()
+		greeter := pb.NewGreeterClient(conn)
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				assert := require.New(t)
+
+				_, err := greeter.SayHello(context.Background(), &pb.HelloRequest{Name: test.name})
+				st := status.Convert(err)
+				assert.Equal(test.code, st.Code())
+				assert.Equal(test.message, st.Message())
+
+				if test.detail == nil {
+					assert.Empty(st.Details())
+					return
+				}
+				assert.Len(st.Details(), 1)
+				assert.Equal(fmt.Sprint(test.detail), fmt.Sprint(st.Details()[0]))
+			})
+		}
+	})
+
+	// only the internal error is logged via l.Error, in full, next to the usual finished call line
+	logged := rt.logged()
+	for _, hidden := range []string{"user 42: not found", "user bob: conflict", "name is required: invalid argument", "loading owner: user 42: not found", "waiting for db: context canceled", "query users at db-host:5432: context canceled", "lookup: db: context deadline exceeded"} {
+		require.Contains(t, logged, `"error": "`+hidden+`"`)
+	}
+	require.Contains(t, logged, "ERROR\tconnecting to db: pq: password authentication failed")
+	require.Contains(t, logged, "errorVerbose")
+	require.Equal(t, 2, strings.Count(logged, "\tERROR\t"))
+}
+
+func TestErrorsStatusCode(t *testing.T) {
+	assert := require.New(t)
+
+	// the errors with details are understood by grpc without the interceptors too
+	assert.Equal(codes.NotFound, status.Code(NotFound("user", "42")))
+	assert.True(errors.Is(NotFound("user", "42"), ErrNotFound))
+	assert.True(errors.Is(errors.Wrap(Conflict("user", "bob", "taken"), "creating user"), ErrConflict))
+	assert.Equal("user 42: not found", NotFound("user", "42").Error())
+}
@@ -4,24 +4,32 @@
 package grpc
 
 import (
+	"context"
 	"crypto/tls"
-	"fmt"
+	"crypto/x509"
 	"net"
+	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
-	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/packethost/pkg/env"
 	"github.com/packethost/pkg/log"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
 )
 
 // Server is used to hold configured info and ultimately the grpc server
 type Server struct {
+	log       log.Logger
 	err       error
 	server    *grpc.Server
 	cert      tls.Certificate
@@ -30,10 +38,42 @@ type Server struct {
 	unariers  []grpc.UnaryServerInterceptor
 	registry  []func(*grpc.Server)
 
-	mu       sync.RWMutex
-	port     int
-	once     sync.Once
-	listener net.Listener
+	mu             sync.RWMutex
+	host           string
+	port           int
+	unixSocket     string
+	unixSocketMode os.FileMode
+	customListener net.Listener
+	once           sync.Once
+	listener       net.Listener
+	listenErr      error
+	// stopped is set once Shutdown has started, so a Serve racing with it doesn't report the server as serving
+	stopped bool
+
+	drainTimeout     time.Duration
+	limits           limits
+	limiter          *limiter
+	deadlines        *deadlines
+	validate         bool
+	subject          func(context.Context) string
+	skips            skips
+	registerer       prometheus.Registerer
+	constLabels      prometheus.Labels
+	histogram        bool
+	histogramBuckets []float64
+	metrics          *metrics
+	clientCAs        *x509.CertPool
+	clientAuth       tls.ClientAuthType
+	minTLSVersion    uint16
+	reloader         *certReloader
+	reloadInterval   time.Duration
+	health           *health.Server
+	streamTracing    grpc.StreamServerInterceptor
+	reflection       bool
+	channelz         bool
+	admin            *adminServer
+	mux              *muxServer
+	serving          int32
 }
 
 // The ServiceRegister type is used as a callback once the underlying grpc server is setup to register the main service.
@@ -47,29 +87,43 @@ type Option func(*Server)
 // By default the server will be an insecure server listening on port 8080 with logging and prometheus interceptors setup.
 //
 // The server's port is configured via the GRPC_PORT env variable, but can be overriden by the Port helper func.
-// A tls server is setup if keys are provided in either the environment variables GRPC_CERT/GRPC_KEY, or using the X509KeyPair or LoadX509KeyPair helper funcs.
+// The server listens on all interfaces, unless a host is configured via the GRPC_ADDR env variable or the Host helper func.
+// The UnixSocket and Listener helper funcs can be used to serve on a unix domain socket or a caller supplied listener instead.
+// The time allowed for in-flight RPCs to drain during ServeContext's shutdown is configured via the GRPC_DRAIN_TIMEOUT env variable (default 30s), but can be overriden by the DrainTimeout helper func.
+// Keepalive, max connection age, message size and concurrent stream limits are configured via the GRPC_KEEPALIVE_*, GRPC_MAX_CONNECTION_*, GRPC_MAX_*_MSG_SIZE and GRPC_MAX_CONCURRENT_STREAMS env variables,
+// but can be overriden by the KeepaliveParams, KeepaliveEnforcementPolicy, MaxConnectionAge, MaxRecvMsgSize, MaxSendMsgSize and MaxConcurrentStreams helper funcs.
+// A tls server is setup if keys are provided in either the environment variables GRPC_CERT/GRPC_KEY, or using the X509KeyPair, LoadX509KeyPair or ReloadX509KeyPair helper funcs.
+// Client certificates are verified (mTLS) if CAs are provided in either the environment variable GRPC_CLIENT_CA, or using the ClientCAs or LoadClientCAs helper funcs.
+// The verified client identity is available to handlers via PeerIdentityFromContext.
 // Logging is always setup using the provided log.Logger.
-// Prometheus is always setup using the default prom interceptors and Register func.
-// OpenTelemetry is setup for unary servers, but NOT streaming servers. Use StreamingInterceptor to add it if you really want/need it.
+// Every RPC gets a request ID, taken from the x-request-id metadata or generated, which is returned in the response header, logged and available via RequestIDFromContext.
+// Handlers can get a copy of it enriched with the grpc method, peer, request ID, trace IDs and subject (see LogSubject) via log.GetLogger.
+// Panics in handlers are always recovered from, logged (and so reported to rollbar) and returned to the client as codes.Internal.
+// Errors returned by handlers are always mapped to status codes, see ErrNotFound and friends, other errors are logged in full and returned to the client as codes.Internal.
+// Prometheus is always setup, by default using the go-grpc-prometheus defaults and the default registry.
+// A registry of the server's own, handling time histograms and const labels, e.g. the service name, can be setup using the MetricsRegisterer, HandlingTimeHistogram and MetricsConstLabels helper funcs.
+// The standard grpc health service can be setup using the Health helper func.
+// Deadlines for RPCs sent without one, and a cap on all deadlines, can be setup using the DefaultTimeout, MethodTimeout and MaxTimeout helper funcs.
+// Incoming messages can be validated using their protoc-gen-validate generated methods with the ValidateRequests helper func.
+// RPCs can be authenticated using the Auth helper func.
+// Methods, e.g. health checks, can be excluded from logging, tracing, metrics and auth using the SkipLogging, SkipTracing, SkipMetrics and SkipAuth helper funcs.
+// Rate and concurrency limits can be setup using the RateLimit, MethodRateLimit and MaxInFlight helper funcs.
+// Plain http can be served on the same port as grpc using the HTTPHandler helper func.
+// A companion http server for metrics, pprof and health endpoints can be setup using the Admin or AdminPort helper funcs.
+// The reflection and channelz services can be setup via the GRPC_REFLECTION and GRPC_CHANNELZ env variables, or the Reflection and Channelz helper funcs.
+// OpenTelemetry is setup for unary servers, but NOT streaming servers. Use TraceStreams to add it, with control over which messages are recorded.
+// Spans are recorded using the global otel TracerProvider, see the tracing package to set one up.
 //
 // req is called after the server has been setup.
 // This is where your service is gets registered with grpc, equivalent to pb.RegisterMyServiceServer(s, &myServiceImpl{}).
 //
 // After your service has been registered any callbacks that were setup with Register will be called to finish up registration.
 func NewServer(l log.Logger, reg ServiceRegister, options ...Option) (*Server, error) {
-	s := &Server{}
-
-	logStream, logUnary := l.GRPCLoggers()
-	s.streamers = append(s.streamers,
-		logStream,
-		grpc_prometheus.StreamServerInterceptor,
-	)
-	s.unariers = append(s.unariers,
-		logUnary,
-		grpc_prometheus.UnaryServerInterceptor,
-		otelgrpc.UnaryServerInterceptor(),
-	)
-	s.registry = append(s.registry, grpc_prometheus.Register)
+	s := &Server{
+		log:           l,
+		clientAuth:    tls.RequireAndVerifyClientCert,
+		minTLSVersion: tls.VersionTLS12,
+	}
 
 	for _, opt := range options {
 		opt(s)
@@ -78,14 +132,82 @@ func NewServer(l log.Logger, reg ServiceRegister, options ...Option) (*Server, e
 		}
 	}
 
+	if err := s.setupMetrics(); err != nil {
+		return nil, err
+	}
+	if s.reloader != nil {
+		s.reloader.recordExpiry(s.metrics.certExpiry.WithLabelValues(s.reloader.certFile))
+	}
+
+	// the default interceptors are built once the options are known and go before the caller's
+	logStream, logUnary := l.GRPCLoggers()
+	recoveryStream, recoveryUnary := recoveryInterceptors(l, s.metrics)
+	errorStream, errorUnary := errorInterceptors(l)
+	streamers := []grpc.StreamServerInterceptor{
+		unlessStream(&s.skips.logging, logStream),
+		requestIDStreamInterceptor,
+		s.deadlineStreamInterceptor,
+		peerIdentityStreamInterceptor,
+		unlessStream(&s.skips.metrics, s.metrics.grpc.StreamServerInterceptor()),
+	}
+	if s.streamTracing != nil {
+		streamers = append(streamers, s.streamTracing)
+	}
+	// recovery sits inside metrics and tracing so panicking RPCs are recorded as handled with codes.Internal
+	s.streamers = append(append(streamers, recoveryStream, errorStream), s.streamers...)
+	s.unariers = append([]grpc.UnaryServerInterceptor{
+		unlessUnary(&s.skips.logging, logUnary),
+		requestIDUnaryInterceptor,
+		s.deadlineUnaryInterceptor,
+		peerIdentityUnaryInterceptor,
+		unlessUnary(&s.skips.metrics, s.metrics.grpc.UnaryServerInterceptor()),
+		unlessUnary(&s.skips.tracing, otelgrpc.UnaryServerInterceptor()),
+		recoveryUnary,
+		errorUnary,
+	}, s.unariers...)
+	s.registry = append(s.registry, s.metrics.grpc.InitializeMetrics)
+
+	// the request logger goes after the caller's interceptors so it can make use of what they have added to the context, e.g. the authenticated subject
+	s.streamers = append(s.streamers, s.loggerStreamInterceptor)
+	s.unariers = append(s.unariers, s.loggerUnaryInterceptor)
+
+	// the limiter goes last so it can make use of anything the other interceptors, e.g. auth, have added to the context
+	if s.limiter != nil {
+		s.limiter.metrics = s.metrics
+		s.streamers = append(s.streamers, s.limiter.streamInterceptor)
+		s.unariers = append(s.unariers, s.limiter.unaryInterceptor)
+	}
+	// validation runs closest to the handler so rejected RPCs don't pay for it
+	if s.validate {
+		s.streamers = append(s.streamers, validateStreamInterceptor)
+		s.unariers = append(s.unariers, validateUnaryInterceptor)
+	}
+
 	if err := maybeSetPortFromEnv(s); err != nil {
 		return nil, err
 	}
 
+	maybeSetHostFromEnv(s)
+
 	if err := maybeSetTLSFromEnv(s); err != nil {
 		return nil, err
 	}
 
+	if err := maybeSetDrainTimeoutFromEnv(s); err != nil {
+		return nil, err
+	}
+
+	if err := maybeSetAdminPortFromEnv(s); err != nil {
+		return nil, err
+	}
+
+	maybeSetDebugFromEnv(s)
+
+	if err := maybeSetLimitsFromEnv(s); err != nil {
+		return nil, err
+	}
+	s.options = append(s.options, s.limits.serverOptions()...)
+
 	s.options = append(s.options,
 		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(s.streamers...)),
 		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(s.unariers...)),
@@ -99,10 +221,13 @@ func NewServer(l log.Logger, reg ServiceRegister, options ...Option) (*Server, e
 		r(s.server)
 	}
 
+	s.setServing()
+
 	return s, nil
 }
 
-// Port returns the port the server is listening on
+// Port returns the port the server is listening on.
+// It is 0 once listening if the server is not listening on tcp, see Addr.
 func (s *Server) Port() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -114,49 +239,193 @@ func (s *Server) Server() *grpc.Server {
 	return s.server
 }
 
+// errStopped is returned by listen once Shutdown has been called, Serve and ServeContext treat it as a clean stop
+var errStopped = errors.New("server has been shut down")
+
 func (s *Server) listen() error {
-	var err error
 	s.once.Do(func() {
+		var err error
+		defer func() { s.listenErr = err }()
+
 		s.mu.RLock()
 		port := s.port
+		stopped := s.stopped
 		s.mu.RUnlock()
-
-		s.listener, err = net.Listen("tcp", fmt.Sprintf(":%d", port)) //nolint:ineffassign // we do in fact want to assign to err in the outer scope
-		if err != nil {
-			err = errors.Wrap(err, "listen")
+		if stopped {
+			if s.customListener != nil {
+				s.customListener.Close()
+			}
+			err = errStopped
 			return
 		}
 
-		var p string
-		_, p, err = net.SplitHostPort(s.listener.Addr().String()) //nolint:ineffassign // we do in fact want to assign to err in the outer scope
+		var listener net.Listener
+		switch {
+		case s.customListener != nil:
+			listener = s.customListener
+		case s.unixSocket != "":
+			listener, err = listenUnix(s.unixSocket, s.unixSocketMode)
+		default:
+			listener, err = net.Listen("tcp", net.JoinHostPort(s.host, strconv.Itoa(port))) //nolint:ineffassign // we do in fact want to assign to err in the outer scope
+			if err != nil {
+				err = errors.Wrap(err, "listen")
+			}
+		}
 		if err != nil {
-			err = errors.Wrap(err, "extract server port")
 			return
 		}
 
-		port, err = strconv.Atoi(p)
-		if err != nil {
-			err = errors.Wrap(err, "parse server port")
-			return
+		// only tcp listeners have a port, Addr is the source of truth for the others
+		port = 0
+		if _, ok := listener.Addr().(*net.TCPAddr); ok {
+			var p string
+			_, p, err = net.SplitHostPort(listener.Addr().String()) //nolint:ineffassign // we do in fact want to assign to err in the outer scope
+			if err != nil {
+				listener.Close()
+				err = errors.Wrap(err, "extract server port")
+				return
+			}
+
+			port, err = strconv.Atoi(p)
+			if err != nil {
+				listener.Close()
+				err = errors.Wrap(err, "parse server port")
+				return
+			}
 		}
 
+		// Shutdown closes s.listener, so it mustn't be set once Shutdown has started
 		s.mu.Lock()
+		if s.stopped {
+			s.mu.Unlock()
+			listener.Close()
+			err = errStopped
+			return
+		}
+		s.listener = listener
 		s.port = port
 		s.mu.Unlock()
+
+		if s.admin != nil {
+			err = s.admin.listen()
+			if err != nil {
+				s.listener.Close()
+				return
+			}
+		}
 	})
 
-	return err
+	return s.listenErr
 }
 
 // Serve starts the grpc server
 func (s *Server) Serve() error {
 	err := s.listen()
+	if err == errStopped {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
 
 	defer s.listener.Close()
-	return errors.Wrap(s.server.Serve(s.listener), "serve")
+
+	if s.admin != nil {
+		s.admin.start(s.adminHandler(), s.log)
+		defer s.admin.shutdown(context.Background())
+	}
+
+	if s.reloader != nil {
+		defer s.reloader.watch(s.reloadInterval)()
+	}
+
+	s.mu.Lock()
+	if !s.stopped {
+		atomic.StoreInt32(&s.serving, 1)
+	}
+	s.mu.Unlock()
+	defer atomic.StoreInt32(&s.serving, 0)
+
+	if s.mux != nil {
+		return s.serveMux()
+	}
+	err = s.server.Serve(s.listener)
+	if err == grpc.ErrServerStopped {
+		// Shutdown got in before grpc started serving
+		return nil
+	}
+	return errors.Wrap(err, "serve")
+}
+
+// ServeContext starts the grpc server and blocks until ctx is done, SIGTERM/SIGINT is received or the server fails.
+// Once ctx is done or a signal is received the server is shutdown gracefully, see Shutdown, waiting at most the drain timeout for in-flight RPCs to finish.
+func (s *Server) ServeContext(ctx context.Context) error {
+	err := s.listen()
+	if err == errStopped {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(sigs)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Serve()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	case <-sigs:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.drainTimeout)
+	defer cancel()
+
+	err = s.Shutdown(ctx)
+	if serr := <-errs; err == nil {
+		err = serr
+	}
+	return err
+}
+
+// Shutdown gracefully stops the server, new connections and RPCs are refused while in-flight RPCs are allowed to finish.
+// If ctx is done before all RPCs have finished the server is stopped forcefully, cancelling the remaining RPCs, and ctx.Err() is returned.
+// The listener opened by Serve, and the admin http server if enabled, are closed once Shutdown returns.
+// If the Health option is used all services are set to NOT_SERVING before draining starts.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	atomic.StoreInt32(&s.serving, 0)
+	s.mu.Unlock()
+	if s.health != nil {
+		s.health.Shutdown()
+	}
+	var err error
+	if s.mux != nil {
+		err = s.shutdownMux(ctx)
+	} else {
+		err = s.shutdownGRPC(ctx)
+	}
+
+	s.mu.RLock()
+	listener := s.listener
+	s.mu.RUnlock()
+	if listener != nil {
+		// the listener may have already been closed by grpc, nothing useful to do with the error
+		_ = listener.Close()
+	}
+	if s.admin != nil {
+		if aerr := s.admin.shutdown(ctx); err == nil {
+			err = aerr
+		}
+	}
+	return err
 }
 
 // ServerOption will add the opt param to the underlying grpc.NewServer() call.
@@ -195,10 +464,56 @@ func Port(port int) Option {
 	}
 }
 
-// maybeSetTLSFromEnv will pick up the tls info from the environment, but only if the user hasn't specified the info via `X509KeyPair` or `LoadX509KeyPair`
-func maybeSetTLSFromEnv(s *Server) error {
-	var creds credentials.TransportCredentials
+// shutdownGRPC gracefully stops the grpc server, falling back to stopping it forcefully once ctx is done
+func (s *Server) shutdownGRPC(ctx context.Context) error {
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		<-stopped
+		return errors.Wrap(ctx.Err(), "graceful shutdown")
+	}
+}
 
+// maybeSetDrainTimeoutFromEnv will pick up the drain timeout from the environment, but only if the user hasn't specified it via `DrainTimeout`
+func maybeSetDrainTimeoutFromEnv(s *Server) error {
+	if s.drainTimeout != 0 {
+		return nil
+	}
+
+	timeout, err := time.ParseDuration(env.Get("GRPC_DRAIN_TIMEOUT", "30s"))
+	if err != nil {
+		return errors.Wrap(err, "parse grpc drain timeout from env")
+	}
+	if timeout <= 0 {
+		return errors.New("drain timeout must be > 0")
+	}
+
+	s.drainTimeout = timeout
+	return nil
+}
+
+// DrainTimeout will set how long ServeContext waits for in-flight RPCs to finish before forcefully stopping the server, timeout must be > 0
+func DrainTimeout(timeout time.Duration) Option {
+	return func(s *Server) {
+		if timeout <= 0 {
+			s.err = errors.New("drain timeout must be > 0")
+		}
+
+		s.drainTimeout = timeout
+	}
+}
+
+// maybeSetTLSFromEnv will pick up the tls info from the environment, but only if the user hasn't specified the info via `X509KeyPair` or `LoadX509KeyPair`.
+// The same applies to client CAs set via `ClientCAs` or `LoadClientCAs`.
+func maybeSetTLSFromEnv(s *Server) error {
 	if s.cert.PrivateKey == nil {
 		cert := env.Get("GRPC_CERT")
 		key := env.Get("GRPC_KEY")
@@ -209,15 +524,46 @@ func maybeSetTLSFromEnv(s *Server) error {
 				return errors.Wrap(err, "parse tls files")
 			}
 			s.cert = kp
-			creds = credentials.NewServerTLSFromCert(&kp)
 		}
-	} else {
-		creds = credentials.NewServerTLSFromCert(&s.cert)
 	}
 
-	if creds != nil {
-		s.options = append(s.options, grpc.Creds(creds))
+	if s.clientCAs == nil {
+		if ca := env.Get("GRPC_CLIENT_CA"); ca != "" {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM([]byte(ca)) {
+				return errors.New("parse client ca from env: no certificates found")
+			}
+			s.clientCAs = pool
+		}
+	}
+
+	if s.cert.PrivateKey == nil {
+		if s.clientCAs != nil {
+			return errors.New("client CAs require a server certificate")
+		}
+		return nil
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{s.cert},
+		MinVersion:   s.minTLSVersion,
+	}
+	if s.reloader != nil {
+		config.Certificates = nil
+		config.GetCertificate = s.reloader.getCertificate
+	}
+	if s.clientCAs != nil {
+		config.ClientCAs = s.clientCAs
+		config.ClientAuth = s.clientAuth
+	}
+
+	if s.mux != nil {
+		// tls is terminated by the http server and applies to both grpc and plain http
+		s.mux.tlsConfig = config
+		return nil
 	}
+
+	s.options = append(s.options, grpc.Creds(credentials.NewTLS(config)))
 	return nil
 }
 
@@ -260,7 +606,7 @@ func LoadX509KeyPair(certFile, keyFile string) Option {
 }
 
 // StreamInterceptor adds the argument to the list of interceptors in a grpc_middleware.Chain
-// Logging and Prometheus interceptors are always included in the set. OpenTelemetry is NOT
+// Logging, panic recovery and Prometheus interceptors are always included in the set. OpenTelemetry is NOT
 // included by default on streams because it's noisy and can't be on by default.
 func StreamInterceptor(si grpc.StreamServerInterceptor) Option {
 	return func(s *Server) {
@@ -269,7 +615,7 @@ func StreamInterceptor(si grpc.StreamServerInterceptor) Option {
 }
 
 // UnaryInterceptor adds the argument to the list of interceptors in a grpc_middleware.Chain
-// Logging, Prometheus, and OpenTelemetry interceptors are always included in the set
+// Logging, panic recovery, Prometheus, and OpenTelemetry interceptors are always included in the set
 func UnaryInterceptor(ui grpc.UnaryServerInterceptor) Option {
 	return func(s *Server) {
 		s.unariers = append(s.unariers, ui)
@@ -15,6 +15,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/big"
+	"net"
+	"net/http"
 	"os"
 	"sync"
 	"testing"
@@ -361,3 +363,188 @@ func TestListen(t *testing.T) {
 		assert.NoError(t, connectGRPC(t, s.Port(), ""))
 	})
 }
+
+// blockingServer is a helloworld.GreeterServer whose SayHello blocks until release is closed or the RPC is cancelled
+type blockingServer struct {
+	pb.UnimplementedGreeterServer
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	close(b.started)
+	select {
+	case <-b.release:
+		return &pb.HelloReply{Message: "Hello " + in.Name}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	defer testenv.Clear().Restore()
+
+	l := log.Test(t, svc)
+	assert := require.New(t)
+
+	s, err := NewServer(l, defSrv)
+	assert.NoError(err)
+	assert.Equal(30*time.Second, s.drainTimeout)
+
+	os.Setenv("GRPC_DRAIN_TIMEOUT", "5s")
+	s, err = NewServer(l, defSrv)
+	assert.NoError(err)
+	assert.Equal(5*time.Second, s.drainTimeout)
+
+	s, err = NewServer(l, defSrv, DrainTimeout(time.Second))
+	assert.NoError(err)
+	assert.Equal(time.Second, s.drainTimeout)
+
+	os.Setenv("GRPC_DRAIN_TIMEOUT", "forever")
+	s, err = NewServer(l, defSrv)
+	assert.Error(err)
+	assert.Nil(s)
+
+	os.Setenv("GRPC_DRAIN_TIMEOUT", "-1s")
+	s, err = NewServer(l, defSrv)
+	assert.Error(err)
+	assert.Nil(s)
+
+	s, err = NewServer(l, defSrv, DrainTimeout(0))
+	assert.Error(err)
+	assert.Nil(s)
+}
+
+func TestShutdown(t *testing.T) {
+	t.Run("drains in-flight rpcs", func(t *testing.T) {
+		l := log.Test(t, svc)
+		assert := require.New(t)
+
+		b := &blockingServer{started: make(chan struct{}), release: make(chan struct{})}
+		s, err := NewServer(l, func(s *Server) { pb.RegisterGreeterServer(s.Server(), b) }, Port(1))
+		assert.NoError(err)
+		s.port = 0
+
+		serveErr := make(chan error, 1)
+		assert.NoError(s.listen())
+		go func() { serveErr <- s.Serve() }()
+
+		rpcErr := make(chan error, 1)
+		go func() { rpcErr <- connectGRPC(t, s.Port(), "") }()
+		<-b.started
+
+		shutdownErr := make(chan error, 1)
+		go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+		close(b.release)
+		assert.NoError(<-rpcErr)
+		assert.NoError(<-shutdownErr)
+		assert.NoError(<-serveErr)
+	})
+	t.Run("stops at deadline", func(t *testing.T) {
+		l := log.Test(t, svc)
+		assert := require.New(t)
+
+		b := &blockingServer{started: make(chan struct{}), release: make(chan struct{})}
+		s, err := NewServer(l, func(s *Server) { pb.RegisterGreeterServer(s.Server(), b) }, Port(1))
+		assert.NoError(err)
+		s.port = 0
+
+		serveErr := make(chan error, 1)
+		assert.NoError(s.listen())
+		go func() { serveErr <- s.Serve() }()
+
+		rpcErr := make(chan error, 1)
+		go func() { rpcErr <- connectGRPC(t, s.Port(), "") }()
+		<-b.started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.Error(s.Shutdown(ctx))
+		assert.Error(<-rpcErr)
+		assert.NoError(<-serveErr)
+	})
+	t.Run("before serve has listened", func(t *testing.T) {
+		// Serve may still be opening its listener when Shutdown is called, which is racy so try a few times
+		for i := 0; i < 20; i++ {
+			assert := require.New(t)
+
+			s, err := NewServer(log.Test(t, svc), defSrv, Port(1))
+			assert.NoError(err)
+			s.port = 0
+
+			serveErr := make(chan error, 1)
+			go func() { serveErr <- s.Serve() }()
+
+			assert.NoError(s.Shutdown(context.Background()))
+			assert.NoError(<-serveErr)
+			assert.False(s.ready(context.Background()))
+
+			// whichever won, no listener is left open
+			if addr := s.Addr(); addr != nil {
+				_, err := net.Dial("tcp", addr.String())
+				assert.Error(err)
+			}
+		}
+	})
+	t.Run("closes listener when not serving", func(t *testing.T) {
+		l := log.Test(t, svc)
+		assert := require.New(t)
+
+		s, err := NewServer(l, defSrv, Port(1))
+		assert.NoError(err)
+		s.port = 0
+		assert.NoError(s.listen())
+
+		assert.NoError(s.Shutdown(context.Background()))
+		_, err = s.listener.Accept()
+		assert.Error(err)
+	})
+}
+
+func TestServeContext(t *testing.T) {
+	l := log.Test(t, svc)
+	assert := require.New(t)
+
+	s, err := NewServer(l, defSrv, Port(1))
+	assert.NoError(err)
+	s.port = 0
+	assert.NoError(s.listen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- s.ServeContext(ctx) }()
+
+	assert.NoError(connectGRPC(t, s.Port(), ""))
+	cancel()
+	assert.NoError(<-serveErr)
+	assert.Error(connectGRPC(t, s.Port(), ""))
+}
+
+func TestServeContextCancelled(t *testing.T) {
+	opts := map[string][]Option{
+		"grpc": {Port(1)},
+		"mux":  {Port(1), HTTPHandler(http.NotFoundHandler())},
+	}
+	for name, opts := range opts {
+		t.Run(name, func(t *testing.T) {
+			assert := require.New(t)
+
+			// shutdown starts before the server gets the chance to serve, which is racy so try a few times
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			for i := 0; i < 20; i++ {
+				s, err := NewServer(log.Test(t, svc), defSrv, opts...)
+				assert.NoError(err)
+				s.port = 0
+
+				assert.NoError(s.ServeContext(ctx))
+				assert.False(s.ready(ctx))
+
+				// serving once shut down is a no-op
+				assert.NoError(s.Serve())
+				assert.False(s.ready(ctx))
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Health will register the standard grpc.health.v1.Health service.
+// Once NewServer returns every registered service, as well as the server as a whole (the "" service), reports SERVING.
+// Use HealthServer to flip the status of individual services.
+// All services are set to NOT_SERVING as soon as Shutdown is called.
+func Health() Option {
+	return func(s *Server) {
+		if s.health != nil {
+			return
+		}
+
+		s.health = health.NewServer()
+		s.registry = append(s.registry, func(gs *grpc.Server) {
+			healthpb.RegisterHealthServer(gs, s.health)
+		})
+	}
+}
+
+// HealthServer returns the health server registered by the Health option, or nil if Health was not used.
+func (s *Server) HealthServer() *health.Server {
+	return s.health
+}
+
+// setServing marks all the services registered on the grpc server as SERVING
+func (s *Server) setServing() {
+	if s.health == nil {
+		return
+	}
+
+	for name := range s.server.GetServiceInfo() {
+		s.health.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func checkHealth(t *testing.T, port int, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", port), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, err
+	}
+	return resp.Status, nil
+}
+
+func TestHealth(t *testing.T) {
+	t.Run("not registered by default", func(t *testing.T) {
+		l := log.Test(t, svc)
+		assert := require.New(t)
+
+		s, err := NewServer(l, defSrv)
+		assert.NoError(err)
+		assert.Nil(s.HealthServer())
+		serve(t, s, func() {
+			_, err := checkHealth(t, s.Port(), "")
+			assert.Error(err)
+		})
+	})
+	t.Run("services are serving", func(t *testing.T) {
+		l := log.Test(t, svc)
+		assert := require.New(t)
+
+		s, err := NewServer(l, defSrv, Health(), Health())
+		assert.NoError(err)
+		assert.NotNil(s.HealthServer())
+		serve(t, s, func() {
+			for _, service := range []string{"", "helloworld.Greeter", "grpc.health.v1.Health"} {
+				status, err := checkHealth(t, s.Port(), service)
+				assert.NoError(err)
+				assert.Equal(healthpb.HealthCheckResponse_SERVING, status, service)
+			}
+
+			_, err := checkHealth(t, s.Port(), "unknown.Service")
+			assert.Error(err)
+
+			s.HealthServer().SetServingStatus("helloworld.Greeter", healthpb.HealthCheckResponse_NOT_SERVING)
+			status, err := checkHealth(t, s.Port(), "helloworld.Greeter")
+			assert.NoError(err)
+			assert.Equal(healthpb.HealthCheckResponse_NOT_SERVING, status)
+		})
+	})
+	t.Run("not serving once shutdown starts", func(t *testing.T) {
+		l := log.Test(t, svc)
+		assert := require.New(t)
+
+		b := &blockingServer{started: make(chan struct{}), release: make(chan struct{})}
+		s, err := NewServer(l, func(s *Server) { pb.RegisterGreeterServer(s.Server(), b) }, Health(), Port(1))
+		assert.NoError(err)
+		s.port = 0
+
+		serveErr := make(chan error, 1)
+		assert.NoError(s.listen())
+		go func() { serveErr <- s.Serve() }()
+
+		rpcErr := make(chan error, 1)
+		go func() { rpcErr <- connectGRPC(t, s.Port(), "") }()
+		<-b.started
+
+		shutdownErr := make(chan error, 1)
+		go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+		req := &healthpb.HealthCheckRequest{Service: "helloworld.Greeter"}
+		assert.Eventually(func() bool {
+			resp, err := s.HealthServer().Check(context.Background(), req)
+			return err == nil && resp.Status == healthpb.HealthCheckResponse_NOT_SERVING
+		}, time.Second, time.Millisecond)
+
+		close(b.release)
+		assert.NoError(<-rpcErr)
+		assert.NoError(<-shutdownErr)
+		assert.NoError(<-serveErr)
+	})
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"time"
+
+	"github.com/packethost/pkg/env"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// limits holds the connection level settings of the server, zero values mean grpc's defaults are used
+type limits struct {
+	keepalive            keepalive.ServerParameters
+	enforcement          keepalive.EnforcementPolicy
+	maxRecvMsgSize       int
+	maxSendMsgSize       int
+	maxConcurrentStreams uint32
+}
+
+// KeepaliveParams sets the keepalive and max-age parameters for the server.
+// Fields left as zero are picked up from the GRPC_KEEPALIVE_TIME, GRPC_KEEPALIVE_TIMEOUT, GRPC_MAX_CONNECTION_IDLE, GRPC_MAX_CONNECTION_AGE and GRPC_MAX_CONNECTION_AGE_GRACE env variables.
+func KeepaliveParams(params keepalive.ServerParameters) Option {
+	return func(s *Server) {
+		s.limits.keepalive = params
+	}
+}
+
+// KeepaliveEnforcementPolicy sets the keepalive enforcement policy for the server, clients pinging more often than allowed are disconnected.
+// Fields left as zero are picked up from the GRPC_KEEPALIVE_MIN_TIME and GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM env variables.
+func KeepaliveEnforcementPolicy(policy keepalive.EnforcementPolicy) Option {
+	return func(s *Server) {
+		s.limits.enforcement = policy
+	}
+}
+
+// MaxConnectionAge sets how long a connection may exist before it is gracefully closed, with grace being the time allowed for in-flight RPCs to finish afterwards.
+// This makes long-lived connections rebalance behind L4 load balancers.
+// This function overrides the GRPC_MAX_CONNECTION_AGE and GRPC_MAX_CONNECTION_AGE_GRACE env variables.
+func MaxConnectionAge(age, grace time.Duration) Option {
+	return func(s *Server) {
+		if age <= 0 || grace < 0 {
+			s.err = errors.New("max connection age must be > 0 and grace must be >= 0")
+			return
+		}
+
+		s.limits.keepalive.MaxConnectionAge = age
+		s.limits.keepalive.MaxConnectionAgeGrace = grace
+	}
+}
+
+// MaxRecvMsgSize sets the max message size in bytes the server can receive, grpc's default is 4MB.
+// This function overrides the GRPC_MAX_RECV_MSG_SIZE env variable.
+func MaxRecvMsgSize(bytes int) Option {
+	return func(s *Server) {
+		if bytes < 1 {
+			s.err = errors.New("max recv msg size must be > 0")
+			return
+		}
+
+		s.limits.maxRecvMsgSize = bytes
+	}
+}
+
+// MaxSendMsgSize sets the max message size in bytes the server can send, grpc's default is math.MaxInt32.
+// This function overrides the GRPC_MAX_SEND_MSG_SIZE env variable.
+func MaxSendMsgSize(bytes int) Option {
+	return func(s *Server) {
+		if bytes < 1 {
+			s.err = errors.New("max send msg size must be > 0")
+			return
+		}
+
+		s.limits.maxSendMsgSize = bytes
+	}
+}
+
+// MaxConcurrentStreams limits the number of concurrent streams (RPCs) per client connection.
+// This function overrides the GRPC_MAX_CONCURRENT_STREAMS env variable.
+func MaxConcurrentStreams(n uint32) Option {
+	return func(s *Server) {
+		if n < 1 {
+			s.err = errors.New("max concurrent streams must be > 0")
+			return
+		}
+
+		s.limits.maxConcurrentStreams = n
+	}
+}
+
+// maybeSetLimitsFromEnv will pick up the keepalive, message size and stream limits from the environment, but only for the ones the user hasn't specified via the helper funcs
+func maybeSetLimitsFromEnv(s *Server) (err error) {
+	// the env helpers panic on values that fail to parse
+	defer func() {
+		if r := recover(); r != nil {
+			rerr, ok := r.(error)
+			if !ok {
+				rerr = errors.Errorf("%v", r)
+			}
+			err = errors.WithMessage(rerr, "parse grpc limits from env")
+		}
+	}()
+
+	l := &s.limits
+	setDuration(&l.keepalive.Time, "GRPC_KEEPALIVE_TIME")
+	setDuration(&l.keepalive.Timeout, "GRPC_KEEPALIVE_TIMEOUT")
+	setDuration(&l.keepalive.MaxConnectionIdle, "GRPC_MAX_CONNECTION_IDLE")
+	setDuration(&l.keepalive.MaxConnectionAge, "GRPC_MAX_CONNECTION_AGE")
+	setDuration(&l.keepalive.MaxConnectionAgeGrace, "GRPC_MAX_CONNECTION_AGE_GRACE")
+	setDuration(&l.enforcement.MinTime, "GRPC_KEEPALIVE_MIN_TIME")
+	if !l.enforcement.PermitWithoutStream {
+		l.enforcement.PermitWithoutStream = env.Bool("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM")
+	}
+	if l.maxRecvMsgSize == 0 {
+		l.maxRecvMsgSize = env.Int("GRPC_MAX_RECV_MSG_SIZE")
+	}
+	if l.maxSendMsgSize == 0 {
+		l.maxSendMsgSize = env.Int("GRPC_MAX_SEND_MSG_SIZE")
+	}
+	if l.maxConcurrentStreams == 0 {
+		n := env.Int("GRPC_MAX_CONCURRENT_STREAMS")
+		if n < 0 {
+			return errors.New("max concurrent streams must be > 0")
+		}
+		l.maxConcurrentStreams = uint32(n)
+	}
+	if l.maxRecvMsgSize < 0 || l.maxSendMsgSize < 0 {
+		return errors.New("max msg sizes must be > 0")
+	}
+
+	return nil
+}
+
+func setDuration(d *time.Duration, name string) {
+	if *d == 0 {
+		*d = env.Duration(name)
+	}
+}
+
+// serverOptions returns the grpc server options for the limits that have been set
+func (l limits) serverOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if l.keepalive != (keepalive.ServerParameters{}) {
+		opts = append(opts, grpc.KeepaliveParams(l.keepalive))
+	}
+	if l.enforcement != (keepalive.EnforcementPolicy{}) {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(l.enforcement))
+	}
+	if l.maxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(l.maxRecvMsgSize))
+	}
+	if l.maxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(l.maxSendMsgSize))
+	}
+	if l.maxConcurrentStreams > 0 {
+		opts = append(opts, grpc.MaxConcurrentStreams(l.maxConcurrentStreams))
+	}
+	return opts
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/internal/testenv"
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+func TestLimits(t *testing.T) {
+	defer testenv.Clear().Restore()
+
+	t.Run("defaults", func(t *testing.T) {
+		s, err := NewServer(log.Test(t, svc), defSrv)
+		require.NoError(t, err)
+		require.Equal(t, limits{}, s.limits)
+		require.Empty(t, s.limits.serverOptions())
+	})
+	t.Run("env", func(t *testing.T) {
+		defer testenv.Clear().Restore()
+		os.Setenv("GRPC_KEEPALIVE_TIME", "1m")
+		os.Setenv("GRPC_KEEPALIVE_TIMEOUT", "10s")
+		os.Setenv("GRPC_MAX_CONNECTION_IDLE", "2m")
+		os.Setenv("GRPC_MAX_CONNECTION_AGE", "30m")
+		os.Setenv("GRPC_MAX_CONNECTION_AGE_GRACE", "1m")
+		os.Setenv("GRPC_KEEPALIVE_MIN_TIME", "30s")
+		os.Setenv("GRPC_KEEPALIVE_PERMIT_WITHOUT_STREAM", "true")
+		os.Setenv("GRPC_MAX_RECV_MSG_SIZE", "1024")
+		os.Setenv("GRPC_MAX_SEND_MSG_SIZE", "2048")
+		os.Setenv("GRPC_MAX_CONCURRENT_STREAMS", "100")
+
+		s, err := NewServer(log.Test(t, svc), defSrv)
+		require.NoError(t, err)
+		require.Equal(t, limits{
+			keepalive: keepalive.ServerParameters{
+				Time:                  time.Minute,
+				Timeout:               10 * time.Second,
+				MaxConnectionIdle:     2 * time.Minute,
+				MaxConnectionAge:      30 * time.Minute,
+				MaxConnectionAgeGrace: time.Minute,
+			},
+			enforcement: keepalive.EnforcementPolicy{
+				MinTime:             30 * time.Second,
+				PermitWithoutStream: true,
+			},
+			maxRecvMsgSize:       1024,
+			maxSendMsgSize:       2048,
+			maxConcurrentStreams: 100,
+		}, s.limits)
+		require.Len(t, s.limits.serverOptions(), 5)
+	})
+	t.Run("options override env", func(t *testing.T) {
+		defer testenv.Clear().Restore()
+		os.Setenv("GRPC_KEEPALIVE_TIME", "1m")
+		os.Setenv("GRPC_MAX_CONNECTION_AGE", "30m")
+		os.Setenv("GRPC_KEEPALIVE_MIN_TIME", "30s")
+		os.Setenv("GRPC_MAX_RECV_MSG_SIZE", "1024")
+		os.Setenv("GRPC_MAX_CONCURRENT_STREAMS", "100")
+
+		s, err := NewServer(log.Test(t, svc), defSrv,
+			KeepaliveParams(keepalive.ServerParameters{Timeout: 5 * time.Second}),
+			MaxConnectionAge(time.Hour, 5*time.Minute),
+			KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: time.Minute}),
+			MaxRecvMsgSize(4096),
+			MaxSendMsgSize(8192),
+			MaxConcurrentStreams(10),
+		)
+		require.NoError(t, err)
+		require.Equal(t, limits{
+			keepalive: keepalive.ServerParameters{
+				Time:                  time.Minute,
+				Timeout:               5 * time.Second,
+				MaxConnectionAge:      time.Hour,
+				MaxConnectionAgeGrace: 5 * time.Minute,
+			},
+			enforcement: keepalive.EnforcementPolicy{
+				MinTime: time.Minute,
+			},
+			maxRecvMsgSize:       4096,
+			maxSendMsgSize:       8192,
+			maxConcurrentStreams: 10,
+		}, s.limits)
+	})
+	t.Run("max recv msg size is enforced", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), defSrv, MaxRecvMsgSize(64))
+		assert.NoError(err)
+		serve(t, s, func() {
+			assert.NoError(sayHelloConn(t, s.Addr().String()))
+
+			conn, err := grpc.Dial(s.Addr().String(), grpc.WithInsecure())
+			assert.NoError(err)
+			defer conn.Close()
+			_, err = pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: strings.Repeat("a", 128)})
+			assert.Equal(codes.ResourceExhausted, status.Code(err))
+		})
+	})
+	t.Run("assert-fail", func(t *testing.T) {
+		tests := map[string][]Option{
+			"max connection age": {MaxConnectionAge(0, time.Second)},
+			"negative grace":     {MaxConnectionAge(time.Second, -time.Second)},
+			"max recv msg size":  {MaxRecvMsgSize(0)},
+			"max send msg size":  {MaxSendMsgSize(-1)},
+			"max streams":        {MaxConcurrentStreams(0)},
+		}
+		for name, opts := range tests {
+			t.Run(name, func(t *testing.T) {
+				s, err := NewServer(log.Test(t, svc), defSrv, opts...)
+				require.Error(t, err)
+				require.Nil(t, s)
+			})
+		}
+
+		envs := map[string]string{
+			"GRPC_KEEPALIVE_TIME":         "often",
+			"GRPC_MAX_RECV_MSG_SIZE":      "big",
+			"GRPC_MAX_SEND_MSG_SIZE":      "-1",
+			"GRPC_MAX_CONCURRENT_STREAMS": "-1",
+		}
+		for name, value := range envs {
+			t.Run(name, func(t *testing.T) {
+				defer testenv.Clear().Restore()
+				os.Setenv(name, value)
+
+				s, err := NewServer(log.Test(t, svc), defSrv)
+				require.Error(t, err)
+				require.Nil(t, s)
+			})
+		}
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"net"
+	"os"
+
+	"github.com/packethost/pkg/env"
+	"github.com/pkg/errors"
+)
+
+// maybeSetHostFromEnv will pick up the host from the environment, but only if the user hasn't specified the host via `Host`
+func maybeSetHostFromEnv(s *Server) {
+	if s.host != "" {
+		return
+	}
+
+	s.host = env.Get("GRPC_ADDR")
+}
+
+// Host will set the host or ip of the interface the server will bind to, by default the server binds to all interfaces.
+// This function overrides the GRPC_ADDR environment variable.
+func Host(host string) Option {
+	return func(s *Server) {
+		if host == "" {
+			s.err = errors.New("host must not be empty")
+			return
+		}
+
+		s.host = host
+	}
+}
+
+// UnixSocket will make the server listen on a unix domain socket at path instead of tcp.
+// A stale socket left behind at path is removed, and the socket's permissions are set to mode once created, mode must not be 0.
+// NewServer will return an error if both UnixSocket and Listener are used.
+func UnixSocket(path string, mode os.FileMode) Option {
+	return func(s *Server) {
+		if s.unixSocket != "" || s.customListener != nil {
+			s.err = errors.New("listener is already set")
+			return
+		}
+		if path == "" {
+			s.err = errors.New("unix socket path must not be empty")
+			return
+		}
+		if mode.Perm() == 0 {
+			s.err = errors.New("unix socket mode must grant some permissions, e.g. 0660")
+			return
+		}
+
+		s.unixSocket = path
+		s.unixSocketMode = mode
+	}
+}
+
+// Listener will make the server serve on the provided listener instead of opening its own, e.g. for systemd socket activation or bufconn in tests.
+// The listener is closed when the server stops.
+// NewServer will return an error if both UnixSocket and Listener are used.
+func Listener(l net.Listener) Option {
+	return func(s *Server) {
+		if s.unixSocket != "" || s.customListener != nil {
+			s.err = errors.New("listener is already set")
+			return
+		}
+		if l == nil {
+			s.err = errors.New("listener must not be nil")
+			return
+		}
+
+		s.customListener = l
+	}
+}
+
+// Addr returns the address the server is listening on, or nil if it is not listening yet
+func (s *Server) Addr() net.Addr {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// listenUnix listens on the unix socket at path, replacing a stale socket if there is one
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Stat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.Errorf("listen: %s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrap(err, "remove stale socket")
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "listen")
+	}
+
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, errors.Wrap(err, "chmod socket")
+	}
+	return l, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/packethost/pkg/internal/testenv"
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func sayHelloConn(t *testing.T, target string, opts ...grpc.DialOption) error {
+	conn, err := grpc.Dial(target, append(opts, grpc.WithInsecure())...)
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: t.Name()})
+	return err
+}
+
+func TestHost(t *testing.T) {
+	defer testenv.Clear().Restore()
+
+	t.Run("option", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), defSrv, Host("127.0.0.1"))
+		assert.NoError(err)
+		assert.Nil(s.Addr())
+		serve(t, s, func() {
+			addr, ok := s.Addr().(*net.TCPAddr)
+			assert.True(ok)
+			assert.Equal("127.0.0.1", addr.IP.String())
+			assert.Equal(s.Port(), addr.Port)
+			assert.NoError(connectGRPC(t, s.Port(), ""))
+		})
+	})
+	t.Run("env", func(t *testing.T) {
+		defer testenv.Clear().Restore()
+		assert := require.New(t)
+
+		os.Setenv("GRPC_ADDR", "127.0.0.1")
+		s, err := NewServer(log.Test(t, svc), defSrv)
+		assert.NoError(err)
+		serve(t, s, func() {
+			addr, ok := s.Addr().(*net.TCPAddr)
+			assert.True(ok)
+			assert.Equal("127.0.0.1", addr.IP.String())
+		})
+	})
+	t.Run("empty", func(t *testing.T) {
+		s, err := NewServer(log.Test(t, svc), defSrv, Host(""))
+		require.Error(t, err)
+		require.Nil(t, s)
+	})
+}
+
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pkg-grpc-testing-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "grpc.sock")
+
+	t.Run("serves", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), defSrv, UnixSocket(path, 0600))
+		assert.NoError(err)
+		serve(t, s, func() {
+			assert.Equal(0, s.Port())
+			assert.Equal("unix", s.Addr().Network())
+			assert.Equal(path, s.Addr().String())
+
+			fi, err := os.Stat(path)
+			assert.NoError(err)
+			assert.Equal(os.FileMode(0600), fi.Mode().Perm())
+
+			assert.NoError(sayHelloConn(t, "unix://"+path))
+		})
+	})
+	t.Run("replaces stale socket", func(t *testing.T) {
+		assert := require.New(t)
+
+		l, err := net.Listen("unix", path)
+		assert.NoError(err)
+		l.(*net.UnixListener).SetUnlinkOnClose(false)
+		l.Close()
+
+		s, err := NewServer(log.Test(t, svc), defSrv, UnixSocket(path, 0660))
+		assert.NoError(err)
+		serve(t, s, func() {
+			assert.NoError(sayHelloConn(t, "unix://"+path))
+		})
+	})
+	t.Run("refuses to replace regular file", func(t *testing.T) {
+		assert := require.New(t)
+
+		file := filepath.Join(dir, "file")
+		assert.NoError(ioutil.WriteFile(file, nil, 0600))
+
+		s, err := NewServer(log.Test(t, svc), defSrv, UnixSocket(file, 0600))
+		assert.NoError(err)
+		assert.Error(s.listen())
+	})
+}
+
+func TestListener(t *testing.T) {
+	t.Run("bufconn", func(t *testing.T) {
+		assert := require.New(t)
+
+		lis := bufconn.Listen(1 << 20)
+		s, err := NewServer(log.Test(t, svc), defSrv, Listener(lis))
+		assert.NoError(err)
+		serve(t, s, func() {
+			assert.Equal(0, s.Port())
+			assert.Equal("bufconn", s.Addr().Network())
+
+			dialer := func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }
+			assert.NoError(sayHelloConn(t, "bufconn", grpc.WithContextDialer(dialer)))
+		})
+	})
+	t.Run("tcp", func(t *testing.T) {
+		assert := require.New(t)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		assert.NoError(err)
+		s, err := NewServer(log.Test(t, svc), defSrv, Listener(lis))
+		assert.NoError(err)
+		serve(t, s, func() {
+			assert.Equal(lis.Addr().(*net.TCPAddr).Port, s.Port())
+			assert.NoError(connectGRPC(t, s.Port(), ""))
+		})
+	})
+	t.Run("assert-fail", func(t *testing.T) {
+		lis := bufconn.Listen(1 << 20)
+		tests := map[string][]Option{
+			"nil listener":        {Listener(nil)},
+			"empty socket path":   {UnixSocket("", 0600)},
+			"no socket mode":      {UnixSocket("grpc.sock", 0)},
+			"no socket perms":     {UnixSocket("grpc.sock", os.ModeSocket)},
+			"listener and socket": {Listener(lis), UnixSocket("grpc.sock", 0600)},
+			"socket and listener": {UnixSocket("grpc.sock", 0600), Listener(lis)},
+		}
+		for name, opts := range tests {
+			t.Run(name, func(t *testing.T) {
+				s, err := NewServer(log.Test(t, svc), defSrv, opts...)
+				require.Error(t, err)
+				require.Nil(t, s)
+			})
+		}
+	})
+}
@@ -0,0 +1,56 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+)
+
+// LogSubject sets the func used to find the authenticated subject of an RPC, e.g. the sub claim of its token via grpc/authz's SubjectFromContext.
+// The subject is added to the request scoped logger and to the log line of the finished RPC.
+func LogSubject(subject func(ctx context.Context) string) Option {
+	return func(s *Server) {
+		if subject == nil {
+			s.err = errors.New("log subject func must not be nil")
+			return
+		}
+
+		s.subject = subject
+	}
+}
+
+// requestLogger returns a copy of ctx carrying a log.Logger enriched with what is known about the RPC, for handlers to get via log.GetLogger
+func (s *Server) requestLogger(ctx context.Context, method string) context.Context {
+	args := []interface{}{"grpc.method", method}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		args = append(args, "peer.address", p.Addr.String())
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		args = append(args, "trace.id", sc.TraceID().String(), "span.id", sc.SpanID().String())
+	}
+	if s.subject != nil {
+		if sub := s.subject(ctx); sub != "" {
+			args = append(args, "authz.subject", sub)
+			log.AddGRPCFields(ctx, "authz.subject", sub)
+		}
+	}
+	return log.ContextWithLogger(ctx, requestIDLogger(ctx, s.log.With(args...)))
+}
+
+func (s *Server) loggerUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(s.requestLogger(ctx, info.FullMethod), req)
+}
+
+func (s *Server) loggerStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = s.requestLogger(ss.Context(), info.FullMethod)
+	return handler(srv, wrapped)
+}
@@ -0,0 +1,87 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/metadata"
+)
+
+// loggingServer is a helloworld.GreeterServer that logs via the logger from its context
+type loggingServer struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (l *loggingServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	log.GetLogger(ctx).Info("saying hello to " + in.Name)
+	return &pb.HelloReply{Message: "Hello " + in.Name}, nil
+}
+
+func TestRequestLogger(t *testing.T) {
+	rt := &recordingT{T: t}
+	assert := require.New(t)
+
+	s, err := NewServer(log.Test(rt, svc), func(s *Server) { pb.RegisterGreeterServer(s.Server(), &loggingServer{}) },
+		LogSubject(func(ctx context.Context) string { return "user@example.com" }),
+	)
+	assert.NoError(err)
+
+	serve(t, s, func() {
+		conn := dialInsecure(t, s.Port())
+		defer conn.Close()
+
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-1234")
+		_, err := pb.NewGreeterClient(conn).SayHello(ctx, &pb.HelloRequest{Name: "logger"})
+		assert.NoError(err)
+	})
+
+	logged := rt.logged()
+	assert.Contains(logged, "saying hello to logger")
+	assert.Contains(logged, `"grpc.method": "/helloworld.Greeter/SayHello"`)
+	assert.Contains(logged, `"peer.address": "127.0.0.1:`)
+	assert.Contains(logged, `"request.id": "req-1234"`)
+	assert.Contains(logged, `"authz.subject": "user@example.com"`)
+	assert.Contains(logged, "finished unary call")
+	for _, line := range strings.Split(logged, "\n") {
+		if strings.Contains(line, "finished unary call") {
+			assert.Contains(line, `"authz.subject": "user@example.com"`)
+		}
+	}
+
+	_, err = NewServer(log.Test(t, svc), defSrv, LogSubject(nil))
+	assert.Error(err)
+}
+
+func TestRequestLoggerTrace(t *testing.T) {
+	rt := &recordingT{T: t}
+	assert := require.New(t)
+
+	s, err := NewServer(log.Test(rt, svc), defSrv)
+	assert.NoError(err)
+
+	sc := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x01, 0x02, 0x03},
+		SpanID:     trace.SpanID{0x04, 0x05, 0x06},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+	_, err = s.loggerUnaryInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: sayHelloMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		log.GetLogger(ctx).Info("traced")
+		return nil, nil
+	})
+	assert.NoError(err)
+
+	logged := rt.logged()
+	assert.Contains(logged, `"trace.id": "`+sc.TraceID().String()+`"`)
+	assert.Contains(logged, `"span.id": "`+sc.SpanID().String()+`"`)
+	assert.NotContains(logged, "authz.subject")
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+// MethodMatcher reports whether an RPC, identified by its full method e.g. /helloworld.Greeter/SayHello, matches.
+// Any func with this signature can be used as a predicate.
+type MethodMatcher func(fullMethod string) bool
+
+// Methods returns a MethodMatcher matching the given full methods exactly, e.g. /helloworld.Greeter/SayHello.
+func Methods(fullMethods ...string) MethodMatcher {
+	set := make(map[string]struct{}, len(fullMethods))
+	for _, m := range fullMethods {
+		set[m] = struct{}{}
+	}
+	return func(fullMethod string) bool {
+		_, ok := set[fullMethod]
+		return ok
+	}
+}
+
+// Services returns a MethodMatcher matching every method of the given services, e.g. grpc.health.v1.Health.
+func Services(services ...string) MethodMatcher {
+	prefixes := make([]string, 0, len(services))
+	for _, svc := range services {
+		prefixes = append(prefixes, "/"+strings.Trim(svc, "/")+"/")
+	}
+	return func(fullMethod string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(fullMethod, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// skips holds the matchers of the methods excluded from each interceptor group
+type skips struct {
+	logging MethodMatcher
+	tracing MethodMatcher
+	metrics MethodMatcher
+	auth    MethodMatcher
+}
+
+// addSkip adds m to the methods already matched by *existing
+func addSkip(s *Server, existing *MethodMatcher, m MethodMatcher) {
+	if m == nil {
+		s.err = errors.New("method matcher must not be nil")
+		return
+	}
+
+	prev := *existing
+	if prev == nil {
+		*existing = m
+		return
+	}
+	*existing = func(fullMethod string) bool {
+		return prev(fullMethod) || m(fullMethod)
+	}
+}
+
+// SkipLogging excludes the methods matched by m from the logging interceptors.
+// It can be called multiple times, a method is excluded if any of the matchers match it.
+func SkipLogging(m MethodMatcher) Option {
+	return func(s *Server) {
+		addSkip(s, &s.skips.logging, m)
+	}
+}
+
+// SkipTracing excludes the methods matched by m from the OpenTelemetry interceptors, including those setup by TraceStreams.
+// It can be called multiple times, a method is excluded if any of the matchers match it.
+func SkipTracing(m MethodMatcher) Option {
+	return func(s *Server) {
+		addSkip(s, &s.skips.tracing, m)
+	}
+}
+
+// SkipMetrics excludes the methods matched by m from the prometheus interceptors.
+// It can be called multiple times, a method is excluded if any of the matchers match it.
+func SkipMetrics(m MethodMatcher) Option {
+	return func(s *Server) {
+		addSkip(s, &s.skips.metrics, m)
+	}
+}
+
+// SkipAuth excludes the methods matched by m from the interceptors setup by Auth.
+// It can be called multiple times, a method is excluded if any of the matchers match it.
+func SkipAuth(m MethodMatcher) Option {
+	return func(s *Server) {
+		addSkip(s, &s.skips.auth, m)
+	}
+}
+
+// unlessUnary returns an interceptor that calls i unless the method is matched by *skip, which is read on every call so it can be set after the chain is built
+func unlessUnary(skip *MethodMatcher, i grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if m := *skip; m != nil && m(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		return i(ctx, req, info, handler)
+	}
+}
+
+// unlessStream is the streaming version of unlessUnary
+func unlessStream(skip *MethodMatcher, i grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if m := *skip; m != nil && m(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		return i(srv, ss, info, handler)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"github.com/packethost/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/codes"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const healthCheckMethod = "/grpc.health.v1.Health/Check"
+
+func TestMethodMatchers(t *testing.T) {
+	assert := require.New(t)
+
+	exact := Methods(sayHelloMethod, healthCheckMethod)
+	assert.True(exact(sayHelloMethod))
+	assert.True(exact(healthCheckMethod))
+	assert.False(exact("/helloworld.Greeter/SayGoodbye"))
+	assert.False(exact("/helloworld.Greeter/"))
+
+	services := Services("grpc.health.v1.Health", "/helloworld.Greeter/")
+	assert.True(services(sayHelloMethod))
+	assert.True(services(healthCheckMethod))
+	assert.True(services("/grpc.health.v1.Health/Watch"))
+	assert.False(services("/grpc.health.v1.HealthCheck/Check"))
+	assert.False(services("/grpc.examples.echo.Echo/UnaryEcho"))
+
+	s := &Server{}
+	SkipLogging(Methods(sayHelloMethod))(s)
+	SkipLogging(func(fullMethod string) bool { return strings.HasSuffix(fullMethod, "/Watch") })(s)
+	assert.NoError(s.err)
+	assert.True(s.skips.logging(sayHelloMethod))
+	assert.True(s.skips.logging("/grpc.health.v1.Health/Watch"))
+	assert.False(s.skips.logging(healthCheckMethod))
+	assert.Nil(s.skips.tracing)
+
+	fails := map[string][]Option{
+		"logging": {SkipLogging(nil)},
+		"tracing": {SkipTracing(nil)},
+		"metrics": {SkipMetrics(nil)},
+		"auth":    {SkipAuth(nil)},
+		"no auth": {Auth(nil)},
+	}
+	for name, opts := range fails {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewServer(log.Test(t, svc), defSrv, opts...)
+			require.Error(t, err)
+		})
+	}
+}
+
+// startedCount returns the value of grpc_server_started_total for method of service
+func startedCount(t *testing.T, service, method string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+
+	total := 0.0
+	for _, family := range families {
+		if family.GetName() != "grpc_server_started_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["grpc_service"] == service && labels["grpc_method"] == method {
+				total += metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return total
+}
+
+func TestSkip(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	rt := &recordingT{T: t}
+	assert := require.New(t)
+
+	health := Services("grpc.health.v1.Health")
+	auth := func(ctx context.Context) (context.Context, error) {
+		token, err := grpc_auth.AuthFromMD(ctx, "bearer")
+		if err != nil {
+			return nil, err
+		}
+		if token != "letmein" {
+			return nil, status.Error(codes.Unauthenticated, "bad token")
+		}
+		return ctx, nil
+	}
+	s, err := NewServer(log.Test(rt, svc), defSrv, Health(), Auth(auth),
+		SkipLogging(health), SkipTracing(health), SkipMetrics(health), SkipAuth(health),
+	)
+	assert.NoError(err)
+
+	checksBefore := startedCount(t, "grpc.health.v1.Health", "Check")
+	helloBefore := startedCount(t, "helloworld.Greeter", "SayHello")
+	spansBefore := len(recorder.Ended())
+
+	serve(t, s, func() {
+		conn := dialInsecure(t, s.Port())
+		defer conn.Close()
+		greeter := pb.NewGreeterClient(conn)
+
+		// health checks skip auth
+		_, err := checkHealth(t, s.Port(), "")
+		assert.NoError(err)
+
+		_, err = greeter.SayHello(context.Background(), &pb.HelloRequest{Name: "skip"})
+		assert.Equal(codes.Unauthenticated, status.Code(err))
+
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer letmein")
+		_, err = greeter.SayHello(ctx, &pb.HelloRequest{Name: "skip"})
+		assert.NoError(err)
+	})
+
+	logged := rt.logged()
+	assert.Contains(logged, `"grpc.service": "helloworld.Greeter"`)
+	assert.NotContains(logged, `"grpc.service": "grpc.health.v1.Health"`)
+
+	assert.Equal(checksBefore, startedCount(t, "grpc.health.v1.Health", "Check"))
+	assert.Equal(helloBefore+2, startedCount(t, "helloworld.Greeter", "SayHello"))
+
+	spans := recorder.Ended()[spansBefore:]
+	assert.Len(spans, 2)
+	for _, span := range spans {
+		assert.Equal("helloworld.Greeter/SayHello", span.Name())
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metrics holds the collectors a server records to
+type metrics struct {
+	grpc             *grpc_prometheus.ServerMetrics
+	certExpiry       *prometheus.GaugeVec
+	panicsRecovered  *prometheus.CounterVec
+	rateLimited      *prometheus.CounterVec
+	deadlineExceeded *prometheus.CounterVec
+}
+
+func newMetrics(grpcMetrics *grpc_prometheus.ServerMetrics) *metrics {
+	return &metrics{
+		grpc: grpcMetrics,
+		certExpiry: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "grpc_server_tls_certificate_expiry_timestamp_seconds",
+			Help: "Unix timestamp of the NotAfter field of the certificate being served.",
+		}, []string{"file"}),
+		panicsRecovered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_panics_recovered_total",
+			Help: "Total number of panics recovered from in RPC handlers.",
+		}, []string{"grpc_method"}),
+		rateLimited: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_rate_limited_total",
+			Help: "Total number of RPCs rejected by the rate or in-flight limits.",
+		}, []string{"grpc_method", "limit"}),
+		deadlineExceeded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_deadline_exceeded_total",
+			Help: "Total number of RPCs that ran past their deadline.",
+		}, []string{"grpc_method"}),
+	}
+}
+
+// defaultMetrics are registered with prometheus.DefaultRegisterer and shared by every server not using MetricsRegisterer, just like go-grpc-prometheus' DefaultServerMetrics
+var defaultMetrics = newMetrics(grpc_prometheus.DefaultServerMetrics)
+
+func init() {
+	prometheus.MustRegister(
+		defaultMetrics.certExpiry,
+		defaultMetrics.panicsRecovered,
+		defaultMetrics.rateLimited,
+		defaultMetrics.deadlineExceeded,
+	)
+}
+
+func (m *metrics) register(reg prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{m.grpc, m.certExpiry, m.panicsRecovered, m.rateLimited, m.deadlineExceeded} {
+		if err := reg.Register(c); err != nil {
+			return errors.Wrap(err, "register metrics")
+		}
+	}
+	return nil
+}
+
+// MetricsRegisterer will register the server's metrics with reg instead of prometheus.DefaultRegisterer, giving the server its own set of metrics.
+// reg must not be prometheus.DefaultRegisterer, go-grpc-prometheus has already registered its defaults there.
+// The admin server's /metrics endpoint serves reg if it is also a prometheus.Gatherer, e.g. a *prometheus.Registry.
+func MetricsRegisterer(reg prometheus.Registerer) Option {
+	return func(s *Server) {
+		if reg == nil {
+			s.err = errors.New("metrics registerer must not be nil")
+			return
+		}
+		s.registerer = reg
+	}
+}
+
+// HandlingTimeHistogram will record the grpc_server_handling_seconds histogram using buckets, or prometheus.DefBuckets if none are given.
+// Without a MetricsRegisterer the histogram is the one of go-grpc-prometheus' DefaultServerMetrics, which is shared by all servers and keeps the buckets it was first enabled with.
+func HandlingTimeHistogram(buckets ...float64) Option {
+	return func(s *Server) {
+		for i := 1; i < len(buckets); i++ {
+			if buckets[i] <= buckets[i-1] {
+				s.err = errors.New("histogram buckets must be in increasing order")
+				return
+			}
+		}
+		s.histogram = true
+		s.histogramBuckets = buckets
+	}
+}
+
+// MetricsConstLabels will add labels, e.g. the service name, to all of the server's metrics.
+// It can be called multiple times, later labels win. It requires a MetricsRegisterer.
+func MetricsConstLabels(labels prometheus.Labels) Option {
+	return func(s *Server) {
+		if s.constLabels == nil {
+			s.constLabels = prometheus.Labels{}
+		}
+		for k, v := range labels {
+			s.constLabels[k] = v
+		}
+	}
+}
+
+// setupMetrics creates and registers the server's metrics once the options are known
+func (s *Server) setupMetrics() error {
+	if s.registerer == nil {
+		if len(s.constLabels) > 0 {
+			return errors.New("metric const labels require a MetricsRegisterer")
+		}
+		if s.histogram {
+			grpc_prometheus.EnableHandlingTimeHistogram(grpc_prometheus.WithHistogramBuckets(s.histogramBuckets))
+		}
+		s.metrics = defaultMetrics
+		return nil
+	}
+
+	grpcMetrics := grpc_prometheus.NewServerMetrics()
+	if s.histogram {
+		grpcMetrics.EnableHandlingTimeHistogram(grpc_prometheus.WithHistogramBuckets(s.histogramBuckets))
+	}
+	m := newMetrics(grpcMetrics)
+	if err := m.register(prometheus.WrapRegistererWith(s.constLabels, s.registerer)); err != nil {
+		return err
+	}
+	s.metrics = m
+	return nil
+}
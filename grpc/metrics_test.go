@@ -0,0 +1,110 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+)
+
+// gatherMetric returns the metrics of the family name gathered from g
+func gatherMetric(t *testing.T, g prometheus.Gatherer, name string) []*dto.Metric {
+	families, err := g.Gather()
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()
+		}
+	}
+	return nil
+}
+
+func labelValues(m *dto.Metric) map[string]string {
+	labels := map[string]string{}
+	for _, label := range m.GetLabel() {
+		labels[label.GetName()] = label.GetValue()
+	}
+	return labels
+}
+
+func TestMetricsRegisterer(t *testing.T) {
+	assert := require.New(t)
+
+	servers := map[string]*prometheus.Registry{"a": prometheus.NewRegistry(), "b": prometheus.NewRegistry()}
+	for name, reg := range servers {
+		s, err := NewServer(log.Test(t, svc), defSrv, Admin(),
+			MetricsRegisterer(reg),
+			HandlingTimeHistogram(0.5, 1),
+			MetricsConstLabels(prometheus.Labels{"service": name}),
+		)
+		assert.NoError(err)
+		s.admin.port = 0
+
+		serve(t, s, func() {
+			conn := dialInsecure(t, s.Port())
+			defer conn.Close()
+			_, err := pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: name})
+			assert.NoError(err)
+
+			code, body, err := adminGet(t, s.AdminPort(), "/metrics")
+			assert.NoError(err)
+			assert.Equal(http.StatusOK, code)
+			assert.Contains(body, `grpc_server_handling_seconds_bucket{grpc_method="SayHello",grpc_service="helloworld.Greeter",grpc_type="unary",service="`+name+`",le="0.5"} 1`)
+		})
+
+		// metrics are pre-initialized for the registered methods, and only the called one has been started
+		started := gatherMetric(t, reg, "grpc_server_started_total")
+		assert.NotEmpty(started)
+		for _, m := range started {
+			labels := labelValues(m)
+			assert.Equal(name, labels["service"])
+			if labels["grpc_method"] == "SayHello" {
+				assert.Equal(1.0, m.GetCounter().GetValue())
+			}
+		}
+
+		handling := gatherMetric(t, reg, "grpc_server_handling_seconds")
+		assert.Len(handling, 1)
+		histogram := handling[0].GetHistogram()
+		assert.Equal(uint64(1), histogram.GetSampleCount())
+		bounds := []float64{}
+		for _, b := range histogram.GetBucket() {
+			bounds = append(bounds, b.GetUpperBound())
+		}
+		assert.Equal([]float64{0.5, 1}, bounds)
+
+		// the server's own metrics are registered too
+		s.metrics.panicsRecovered.WithLabelValues(sayHelloMethod).Inc()
+		panics := gatherMetric(t, reg, "grpc_server_panics_recovered_total")
+		assert.Len(panics, 1)
+		assert.Equal(name, labelValues(panics[0])["service"])
+	}
+
+	// a second server using the same registry and labels collides
+	_, err := NewServer(log.Test(t, svc), defSrv, MetricsRegisterer(servers["a"]), MetricsConstLabels(prometheus.Labels{"service": "a"}))
+	assert.Error(err)
+
+	fails := map[string][]Option{
+		"nil registerer":        {MetricsRegisterer(nil)},
+		"default registerer":    {MetricsRegisterer(prometheus.DefaultRegisterer)},
+		"unordered buckets":     {HandlingTimeHistogram(1, 0.5)},
+		"labels, no registerer": {MetricsConstLabels(prometheus.Labels{"service": "c"})},
+		"invalid label name":    {MetricsRegisterer(prometheus.NewRegistry()), MetricsConstLabels(prometheus.Labels{"not a label": "c"})},
+		"reserved label name":   {MetricsRegisterer(prometheus.NewRegistry()), MetricsConstLabels(prometheus.Labels{"grpc_method": "c"})},
+	}
+	for name, opts := range fails {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewServer(log.Test(t, svc), defSrv, opts...)
+			require.Error(t, err)
+		})
+	}
+}
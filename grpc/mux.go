@@ -0,0 +1,181 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// muxServer serves grpc and plain http on the same listener using an http.Server
+type muxServer struct {
+	handler   http.Handler
+	tlsConfig *tls.Config
+	inflight  int64
+
+	mu     sync.Mutex
+	server *http.Server
+	// conns are the open connections, so those hijacked by h2c, which http.Server doesn't close, can be closed on shutdown
+	conns map[net.Conn]struct{}
+	// closed is set by shutdownMux, so a serveMux racing with it doesn't start serving
+	closed bool
+}
+
+// HTTPHandler will serve plain HTTP/1.1 and non-grpc HTTP/2 requests with h on the same port as grpc, for example a grpc-gateway mux.
+// Requests are routed to grpc if they are HTTP/2 and their content-type is application/grpc, everything else is passed to h.
+// Without tls, HTTP/2 is served as h2c so grpc clients continue to work unchanged.
+// TLS, see X509KeyPair, is terminated by the http server and applies to both protocols.
+//
+// In this mode grpc requests are handled by grpc.Server.ServeHTTP, which does not support some of the grpc-go specific transport features (e.g. keepalive enforcement).
+func HTTPHandler(h http.Handler) Option {
+	return func(s *Server) {
+		if h == nil {
+			s.err = errors.New("http handler must not be nil")
+			return
+		}
+
+		s.mux = &muxServer{handler: h}
+	}
+}
+
+func (m *muxServer) routeHandler(gs http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			atomic.AddInt64(&m.inflight, 1)
+			defer atomic.AddInt64(&m.inflight, -1)
+
+			gs.ServeHTTP(w, r)
+			return
+		}
+		m.handler.ServeHTTP(w, r)
+	})
+}
+
+// trackingListener tracks the connections it accepts until they are closed
+type trackingListener struct {
+	net.Listener
+	m *muxServer
+}
+
+func (l trackingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &trackedConn{Conn: c, m: l.m}
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	if l.m.conns == nil {
+		c.Close()
+		return nil, http.ErrServerClosed
+	}
+	l.m.conns[tc] = struct{}{}
+	return tc, nil
+}
+
+// trackedConn stops being tracked once closed
+type trackedConn struct {
+	net.Conn
+	m    *muxServer
+	once sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		c.m.mu.Lock()
+		delete(c.m.conns, c)
+		c.m.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+func (s *Server) serveMux() error {
+	m := s.mux
+
+	h2s := &http2.Server{}
+	server := &http.Server{
+		Handler: m.routeHandler(s.server),
+	}
+
+	// tracking goes beneath tls so http.Server still sees *tls.Conn
+	var listener net.Listener = trackingListener{Listener: s.listener, m: m}
+	if m.tlsConfig != nil {
+		server.TLSConfig = m.tlsConfig.Clone()
+	} else {
+		server.Handler = h2c.NewHandler(server.Handler, h2s)
+	}
+	if err := http2.ConfigureServer(server, h2s); err != nil {
+		return errors.Wrap(err, "configure http2")
+	}
+	if m.tlsConfig != nil {
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.server = server
+	m.conns = map[net.Conn]struct{}{}
+	m.mu.Unlock()
+
+	err := server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return errors.Wrap(err, "serve")
+}
+
+// shutdown stops accepting new connections and waits for in-flight grpc requests to finish or ctx to be done.
+// Whatever is left over is closed forcefully.
+func (s *Server) shutdownMux(ctx context.Context) error {
+	m := s.mux
+
+	m.mu.Lock()
+	m.closed = true
+	server := m.server
+	m.mu.Unlock()
+
+	var err error
+	if server != nil {
+		err = server.Shutdown(ctx)
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for err == nil && atomic.LoadInt64(&m.inflight) > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	s.server.Stop()
+	if server != nil {
+		server.Close()
+	}
+
+	m.mu.Lock()
+	conns := m.conns
+	m.conns = nil
+	m.mu.Unlock()
+	for c := range conns {
+		c.Close()
+	}
+
+	return errors.Wrap(err, "graceful shutdown")
+}
@@ -0,0 +1,186 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+)
+
+var httpSrv = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "hello http")
+})
+
+// serveMuxed is like serve but uses Shutdown to stop the server since grpc.Server.Stop does not stop the http server
+func serveMuxed(t *testing.T, s *Server, test func()) {
+	s.port = 0
+	require.NoError(t, s.listen())
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- s.Serve() }()
+
+	test()
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	require.NoError(t, <-serveErr)
+}
+
+func httpGet(t *testing.T, url string, cert string) (string, error) {
+	client := http.DefaultClient
+	if cert != "" {
+		cp := x509.NewCertPool()
+		if !cp.AppendCertsFromPEM([]byte(cert)) {
+			t.Fatal("failed to add cert to pool")
+		}
+		client = &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: cp}}}
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), nil
+}
+
+func TestHTTPHandler(t *testing.T) {
+	t.Run("insecure", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), defSrv, HTTPHandler(httpSrv))
+		assert.NoError(err)
+		serveMuxed(t, s, func() {
+			body, err := httpGet(t, fmt.Sprintf("http://localhost:%d/", s.Port()), "")
+			assert.NoError(err)
+			assert.Equal("hello http", body)
+			assert.NoError(connectGRPC(t, s.Port(), ""))
+		})
+	})
+	t.Run("tls", func(t *testing.T) {
+		assert := require.New(t)
+
+		cert, key := genCert(t)
+		s, err := NewServer(log.Test(t, svc), defSrv, HTTPHandler(httpSrv), X509KeyPair(cert, key))
+		assert.NoError(err)
+		serveMuxed(t, s, func() {
+			body, err := httpGet(t, fmt.Sprintf("https://localhost:%d/", s.Port()), cert)
+			assert.NoError(err)
+			assert.Equal("hello http", body)
+			body, _ = httpGet(t, fmt.Sprintf("http://localhost:%d/", s.Port()), "")
+			assert.NotEqual("hello http", body)
+
+			assert.NoError(connectGRPC(t, s.Port(), cert))
+			assert.Error(connectGRPC(t, s.Port(), ""))
+		})
+	})
+	t.Run("mtls identity", func(t *testing.T) {
+		assert := require.New(t)
+
+		cert, key := genCert(t)
+		clientCert, clientKey := genClientCert(t, "client-1")
+		s, err := NewServer(log.Test(t, svc), identitySrv, HTTPHandler(httpSrv), X509KeyPair(cert, key), ClientCAs(clientCert))
+		assert.NoError(err)
+		serveMuxed(t, s, func() {
+			msg, err := sayHelloMTLS(t, s.Port(), cert, clientCert, clientKey, 0)
+			assert.NoError(err)
+			assert.Equal("Hello client-1", msg)
+		})
+	})
+	t.Run("nil handler", func(t *testing.T) {
+		s, err := NewServer(log.Test(t, svc), defSrv, HTTPHandler(nil))
+		require.Error(t, err)
+		require.Nil(t, s)
+	})
+}
+
+func TestHTTPHandlerShutdown(t *testing.T) {
+	start := func(t *testing.T) (*Server, *blockingServer, chan error, chan error) {
+		b := &blockingServer{started: make(chan struct{}), release: make(chan struct{})}
+		s, err := NewServer(log.Test(t, svc), func(s *Server) { pb.RegisterGreeterServer(s.Server(), b) }, HTTPHandler(httpSrv))
+		require.NoError(t, err)
+		s.port = 0
+		require.NoError(t, s.listen())
+
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- s.Serve() }()
+
+		rpcErr := make(chan error, 1)
+		go func() { rpcErr <- connectGRPC(t, s.Port(), "") }()
+		<-b.started
+
+		return s, b, serveErr, rpcErr
+	}
+
+	t.Run("drains in-flight rpcs", func(t *testing.T) {
+		assert := require.New(t)
+		s, b, serveErr, rpcErr := start(t)
+
+		shutdownErr := make(chan error, 1)
+		go func() { shutdownErr <- s.Shutdown(context.Background()) }()
+
+		time.Sleep(50 * time.Millisecond)
+		close(b.release)
+		assert.NoError(<-rpcErr)
+		assert.NoError(<-shutdownErr)
+		assert.NoError(<-serveErr)
+	})
+	t.Run("stops at deadline", func(t *testing.T) {
+		assert := require.New(t)
+		s, _, serveErr, rpcErr := start(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.Error(s.Shutdown(ctx))
+		assert.Error(<-rpcErr)
+		assert.NoError(<-serveErr)
+	})
+}
+
+func TestHTTPHandlerConnTracking(t *testing.T) {
+	assert := require.New(t)
+
+	s, err := NewServer(log.Test(t, svc), defSrv, HTTPHandler(httpSrv))
+	assert.NoError(err)
+	open := func() int {
+		s.mux.mu.Lock()
+		defer s.mux.mu.Unlock()
+		return len(s.mux.conns)
+	}
+
+	serveMuxed(t, s, func() {
+		// closed connections, including those hijacked by h2c, are forgotten
+		for i := 0; i < 5; i++ {
+			assert.NoError(connectGRPC(t, s.Port(), ""))
+		}
+		assert.Eventually(func() bool { return open() == 0 }, 5*time.Second, 10*time.Millisecond)
+	})
+
+	// connections accepted once shut down are closed rather than tracked
+	l, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(err)
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	assert.NoError(err)
+	defer c.Close()
+
+	_, err = trackingListener{Listener: l, m: s.mux}.Accept()
+	assert.Equal(http.ErrServerClosed, err)
+	assert.Zero(open())
+}
@@ -0,0 +1,241 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// bucketIdleTimeout is how long a token bucket can go unused before it is forgotten
+	bucketIdleTimeout = 10 * time.Minute
+	// inFlightRetryAfter is the retry-after hint given to clients rejected by MaxInFlight
+	inFlightRetryAfter = time.Second
+)
+
+type rateSpec struct {
+	limit rate.Limit
+	burst int
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// limiter rejects RPCs that exceed per method token bucket rate limits, optionally partitioned by key, or the global max in-flight cap
+type limiter struct {
+	rate        *rateSpec
+	methods     map[string]rateSpec
+	key         func(context.Context) string
+	maxInFlight int64
+	inFlight    int64
+	metrics     *metrics
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func (s *Server) rateLimiter() *limiter {
+	if s.limiter == nil {
+		s.limiter = &limiter{
+			methods: map[string]rateSpec{},
+			buckets: map[string]*bucket{},
+		}
+	}
+	return s.limiter
+}
+
+// RateLimit limits every RPC method to r requests per second with bursts of up to burst requests, using a token bucket per full method.
+// Rejected RPCs fail with codes.ResourceExhausted, a retry-after header in seconds and are counted in grpc_server_rate_limited_total.
+// Use RateLimitByPeer or RateLimitKey to give each peer or caller its own set of buckets.
+func RateLimit(r float64, burst int) Option {
+	return func(s *Server) {
+		if r <= 0 || burst < 1 {
+			s.err = errors.New("rate limit must be > 0 and burst must be > 0")
+			return
+		}
+
+		s.rateLimiter().rate = &rateSpec{limit: rate.Limit(r), burst: burst}
+	}
+}
+
+// MethodRateLimit limits fullMethod, e.g. /helloworld.Greeter/SayHello, to r requests per second with bursts of up to burst requests.
+// It overrides RateLimit for fullMethod, methods without a limit are not rate limited unless RateLimit is used.
+func MethodRateLimit(fullMethod string, r float64, burst int) Option {
+	return func(s *Server) {
+		if r <= 0 || burst < 1 {
+			s.err = errors.New("rate limit must be > 0 and burst must be > 0")
+			return
+		}
+
+		s.rateLimiter().methods[fullMethod] = rateSpec{limit: rate.Limit(r), burst: burst}
+	}
+}
+
+// RateLimitKey partitions the rate limit buckets by the value key returns for the request's context, e.g. an authenticated subject.
+// The limiter runs after all other interceptors so values they add to the context, such as authz claims, are available to key.
+func RateLimitKey(key func(ctx context.Context) string) Option {
+	return func(s *Server) {
+		if key == nil {
+			s.err = errors.New("rate limit key func must not be nil")
+			return
+		}
+
+		s.rateLimiter().key = key
+	}
+}
+
+// RateLimitByPeer partitions the rate limit buckets by the peer's ip address.
+func RateLimitByPeer() Option {
+	return RateLimitKey(peerHost)
+}
+
+// MaxInFlight caps the number of RPCs, across all methods and peers, being handled at the same time.
+// Rejected RPCs fail with codes.ResourceExhausted, a retry-after header in seconds and are counted in grpc_server_rate_limited_total.
+func MaxInFlight(n int) Option {
+	return func(s *Server) {
+		if n < 1 {
+			s.err = errors.New("max in-flight must be > 0")
+			return
+		}
+
+		s.rateLimiter().maxInFlight = int64(n)
+	}
+}
+
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// allow checks the token bucket for the method and key, returning how long to wait if the request is not allowed
+func (l *limiter) allow(ctx context.Context, method string) (bool, time.Duration) {
+	spec, ok := l.methods[method]
+	if !ok {
+		if l.rate == nil {
+			return true, 0
+		}
+		spec = *l.rate
+	}
+
+	key := method
+	if l.key != nil {
+		key += "\x00" + l.key(ctx)
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(spec.limit, spec.burst)}
+		l.buckets[key] = b
+	}
+	b.lastUsed = now
+	l.mu.Unlock()
+
+	r := b.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep forgets idle buckets so per peer/key buckets do not grow without bound, l.mu must be held
+func (l *limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketIdleTimeout {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.lastUsed) > bucketIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// acquire takes an in-flight slot, the returned func must be called to release it
+func (l *limiter) acquire() (func(), bool) {
+	if l.maxInFlight == 0 {
+		return func() {}, true
+	}
+	if atomic.AddInt64(&l.inFlight, 1) > l.maxInFlight {
+		atomic.AddInt64(&l.inFlight, -1)
+		return nil, false
+	}
+	return func() { atomic.AddInt64(&l.inFlight, -1) }, true
+}
+
+// check applies the limits to the RPC, returning a release func to be called once the RPC is done
+func (l *limiter) check(ctx context.Context, method string) (func(), error) {
+	if ok, delay := l.allow(ctx, method); !ok {
+		return nil, l.rejected(ctx, method, "rate", delay)
+	}
+
+	release, ok := l.acquire()
+	if !ok {
+		return nil, l.rejected(ctx, method, "in_flight", inFlightRetryAfter)
+	}
+	return release, nil
+}
+
+func (l *limiter) rejected(ctx context.Context, method, limit string, retryAfter time.Duration) error {
+	l.metrics.rateLimited.WithLabelValues(method, limit).Inc()
+
+	seconds := int64(math.Ceil(retryAfter.Seconds()))
+	_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.FormatInt(seconds, 10)))
+
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded, retry later")
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func (l *limiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	release, err := l.check(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return handler(ctx, req)
+}
+
+func (l *limiter) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	release, err := l.check(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return handler(srv, ss)
+}
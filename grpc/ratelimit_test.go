@@ -0,0 +1,182 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	echo "google.golang.org/grpc/examples/features/proto/echo"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const sayHelloMethod = "/helloworld.Greeter/SayHello"
+
+// echoServer is a simple echo.EchoServer
+type echoServer struct {
+	echo.UnimplementedEchoServer
+}
+
+func (e *echoServer) UnaryEcho(ctx context.Context, in *echo.EchoRequest) (*echo.EchoResponse, error) {
+	return &echo.EchoResponse{Message: in.Message}, nil
+}
+
+var greeterAndEchoSrv = func(s *Server) {
+	pb.RegisterGreeterServer(s.Server(), &server{})
+	echo.RegisterEchoServer(s.Server(), &echoServer{})
+}
+
+func dialInsecure(t *testing.T, port int) *grpc.ClientConn {
+	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", port), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	return conn
+}
+
+func TestRateLimit(t *testing.T) {
+	t.Run("per method", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), greeterAndEchoSrv, RateLimit(0.001, 2))
+		assert.NoError(err)
+		counter := defaultMetrics.rateLimited.WithLabelValues(sayHelloMethod, "rate")
+		before := testutil.ToFloat64(counter)
+
+		serve(t, s, func() {
+			conn := dialInsecure(t, s.Port())
+			defer conn.Close()
+			greeter := pb.NewGreeterClient(conn)
+
+			for i := 0; i < 2; i++ {
+				_, err := greeter.SayHello(context.Background(), &pb.HelloRequest{Name: "limited"})
+				assert.NoError(err)
+			}
+
+			var header metadata.MD
+			_, err := greeter.SayHello(context.Background(), &pb.HelloRequest{Name: "limited"}, grpc.Header(&header))
+			assert.Equal(codes.ResourceExhausted, status.Code(err))
+			assert.NotEmpty(header.Get("retry-after"))
+			assert.NotEqual("0", header.Get("retry-after")[0])
+
+			details := status.Convert(err).Details()
+			assert.Len(details, 1)
+			assert.IsType(&errdetails.RetryInfo{}, details[0])
+
+			assert.Equal(before+1, testutil.ToFloat64(counter))
+
+			// other methods have their own bucket
+			_, err = echo.NewEchoClient(conn).UnaryEcho(context.Background(), &echo.EchoRequest{Message: "hi"})
+			assert.NoError(err)
+		})
+	})
+	t.Run("method override", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), greeterAndEchoSrv, MethodRateLimit(sayHelloMethod, 0.001, 1))
+		assert.NoError(err)
+
+		serve(t, s, func() {
+			conn := dialInsecure(t, s.Port())
+			defer conn.Close()
+			greeter := pb.NewGreeterClient(conn)
+			echoer := echo.NewEchoClient(conn)
+
+			_, err := greeter.SayHello(context.Background(), &pb.HelloRequest{Name: "limited"})
+			assert.NoError(err)
+			_, err = greeter.SayHello(context.Background(), &pb.HelloRequest{Name: "limited"})
+			assert.Equal(codes.ResourceExhausted, status.Code(err))
+
+			for i := 0; i < 5; i++ {
+				_, err = echoer.UnaryEcho(context.Background(), &echo.EchoRequest{Message: "unlimited"})
+				assert.NoError(err)
+			}
+		})
+	})
+	t.Run("key", func(t *testing.T) {
+		assert := require.New(t)
+
+		user := func(ctx context.Context) string {
+			md, _ := metadata.FromIncomingContext(ctx)
+			return md.Get("user")[0]
+		}
+		s, err := NewServer(log.Test(t, svc), greeterAndEchoSrv, RateLimit(0.001, 1), RateLimitKey(user))
+		assert.NoError(err)
+
+		serve(t, s, func() {
+			conn := dialInsecure(t, s.Port())
+			defer conn.Close()
+			greeter := pb.NewGreeterClient(conn)
+
+			alice := metadata.AppendToOutgoingContext(context.Background(), "user", "alice")
+			bob := metadata.AppendToOutgoingContext(context.Background(), "user", "bob")
+
+			_, err := greeter.SayHello(alice, &pb.HelloRequest{Name: "alice"})
+			assert.NoError(err)
+			_, err = greeter.SayHello(alice, &pb.HelloRequest{Name: "alice"})
+			assert.Equal(codes.ResourceExhausted, status.Code(err))
+			_, err = greeter.SayHello(bob, &pb.HelloRequest{Name: "bob"})
+			assert.NoError(err)
+		})
+	})
+	t.Run("peer", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), greeterAndEchoSrv, RateLimit(0.001, 1), RateLimitByPeer())
+		assert.NoError(err)
+
+		serve(t, s, func() {
+			assert.NoError(connectGRPC(t, s.Port(), ""))
+			assert.Equal(codes.ResourceExhausted, status.Code(connectGRPC(t, s.Port(), "")))
+		})
+	})
+	t.Run("assert-fail", func(t *testing.T) {
+		tests := map[string][]Option{
+			"zero rate":          {RateLimit(0, 1)},
+			"zero burst":         {RateLimit(1, 0)},
+			"method zero rate":   {MethodRateLimit(sayHelloMethod, 0, 1)},
+			"method zero burst":  {MethodRateLimit(sayHelloMethod, 1, 0)},
+			"nil key":            {RateLimitKey(nil)},
+			"zero max in-flight": {MaxInFlight(0)},
+		}
+		for name, opts := range tests {
+			t.Run(name, func(t *testing.T) {
+				s, err := NewServer(log.Test(t, svc), defSrv, opts...)
+				require.Error(t, err)
+				require.Nil(t, s)
+			})
+		}
+	})
+}
+
+func TestMaxInFlight(t *testing.T) {
+	assert := require.New(t)
+
+	b := &blockingServer{started: make(chan struct{}), release: make(chan struct{})}
+	s, err := NewServer(log.Test(t, svc), func(s *Server) { pb.RegisterGreeterServer(s.Server(), b) }, MaxInFlight(1))
+	assert.NoError(err)
+	counter := defaultMetrics.rateLimited.WithLabelValues(sayHelloMethod, "in_flight")
+	before := testutil.ToFloat64(counter)
+
+	serve(t, s, func() {
+		rpcErr := make(chan error, 1)
+		go func() { rpcErr <- connectGRPC(t, s.Port(), "") }()
+		<-b.started
+
+		assert.Equal(codes.ResourceExhausted, status.Code(connectGRPC(t, s.Port(), "")))
+		assert.Equal(before+1, testutil.ToFloat64(counter))
+
+		close(b.release)
+		assert.NoError(<-rpcErr)
+	})
+}
@@ -0,0 +1,35 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+
+	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// recoveryInterceptors returns interceptors that turn a panic in a handler into a codes.Internal error.
+// The panic is logged with its stack trace via l.Error, and so is also reported to rollbar, and counted in grpc_server_panics_recovered_total.
+func recoveryInterceptors(l log.Logger, m *metrics) (grpc.StreamServerInterceptor, grpc.UnaryServerInterceptor) {
+	handler := grpc_recovery.WithRecoveryHandlerContext(func(ctx context.Context, p interface{}) error {
+		method, _ := grpc.Method(ctx)
+		m.panicsRecovered.WithLabelValues(method).Inc()
+
+		err, ok := p.(error)
+		if ok {
+			err = errors.WithStack(err)
+		} else {
+			err = errors.Errorf("%v", p)
+		}
+		requestIDLogger(ctx, l).With("grpc.method", method).Error(errors.WithMessage(err, "recovered from panic"))
+
+		return status.Error(codes.Internal, "internal error")
+	})
+	return grpc_recovery.StreamServerInterceptor(handler), grpc_recovery.UnaryServerInterceptor(handler)
+}
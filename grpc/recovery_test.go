@@ -0,0 +1,115 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	echo "google.golang.org/grpc/examples/features/proto/echo"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+)
+
+// panicServer panics in every handler, unless the request asks for a friendly reply
+type panicServer struct {
+	pb.UnimplementedGreeterServer
+	echo.UnimplementedEchoServer
+}
+
+func (p *panicServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	if in.Name == "friend" {
+		return &pb.HelloReply{Message: "Hello " + in.Name}, nil
+	}
+	panic("the flobnarm overheated")
+}
+
+func (p *panicServer) ServerStreamingEcho(in *echo.EchoRequest, stream echo.Echo_ServerStreamingEchoServer) error {
+	panic(fmt.Errorf("the transducer overheated"))
+}
+
+func TestRecovery(t *testing.T) {
+	assert := require.New(t)
+
+	srv := &panicServer{}
+	s, err := NewServer(log.Test(t, svc), func(s *Server) {
+		pb.RegisterGreeterServer(s.Server(), srv)
+		echo.RegisterEchoServer(s.Server(), srv)
+	})
+	assert.NoError(err)
+
+	unary := defaultMetrics.panicsRecovered.WithLabelValues("/helloworld.Greeter/SayHello")
+	stream := defaultMetrics.panicsRecovered.WithLabelValues("/grpc.examples.echo.Echo/ServerStreamingEcho")
+	unaryBefore := testutil.ToFloat64(unary)
+	streamBefore := testutil.ToFloat64(stream)
+
+	serve(t, s, func() {
+		conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", s.Port()), grpc.WithInsecure())
+		assert.NoError(err)
+		defer conn.Close()
+
+		_, err = pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: "panic"})
+		assert.Equal(codes.Internal, status.Code(err))
+		assert.NotContains(err.Error(), "flobnarm")
+		assert.Equal(unaryBefore+1, testutil.ToFloat64(unary))
+
+		es, err := echo.NewEchoClient(conn).ServerStreamingEcho(context.Background(), &echo.EchoRequest{Message: "panic"})
+		assert.NoError(err)
+		_, err = es.Recv()
+		assert.Equal(codes.Internal, status.Code(err))
+		assert.NotContains(err.Error(), "transducer")
+		assert.Equal(streamBefore+1, testutil.ToFloat64(stream))
+
+		// the server keeps serving after recovering
+		reply, err := pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: "friend"})
+		assert.NoError(err)
+		assert.Equal("Hello friend", reply.Message)
+	})
+}
+
+func TestRecoveryMetrics(t *testing.T) {
+	assert := require.New(t)
+
+	srv := &panicServer{}
+	reg := prometheus.NewRegistry()
+	s, err := NewServer(log.Test(t, svc), func(s *Server) {
+		pb.RegisterGreeterServer(s.Server(), srv)
+		echo.RegisterEchoServer(s.Server(), srv)
+	}, MetricsRegisterer(reg), TraceStreams(nil))
+	assert.NoError(err)
+
+	handled := func(method, code string) float64 {
+		for _, m := range gatherMetric(t, reg, "grpc_server_handled_total") {
+			labels := labelValues(m)
+			if labels["grpc_method"] == method && labels["grpc_code"] == code {
+				return m.GetCounter().GetValue()
+			}
+		}
+		return 0
+	}
+
+	serve(t, s, func() {
+		conn := dialInsecure(t, s.Port())
+		defer conn.Close()
+
+		_, err := pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: "panic"})
+		assert.Equal(codes.Internal, status.Code(err))
+
+		es, err := echo.NewEchoClient(conn).ServerStreamingEcho(context.Background(), &echo.EchoRequest{Message: "panic"})
+		assert.NoError(err)
+		_, err = es.Recv()
+		assert.Equal(codes.Internal, status.Code(err))
+	})
+
+	// panicking RPCs are counted as handled, with the code the client got
+	assert.Equal(float64(1), handled("SayHello", "Internal"))
+	assert.Equal(float64(1), handled("ServerStreamingEcho", "Internal"))
+}
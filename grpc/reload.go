@@ -0,0 +1,173 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// certReloader holds the last successfully loaded certificate and re-reads it from disk when asked to
+type certReloader struct {
+	certFile string
+	keyFile  string
+	log      log.Logger
+
+	mu     sync.RWMutex
+	cert   *tls.Certificate
+	raw    []byte
+	expiry prometheus.Gauge
+}
+
+func newCertReloader(l log.Logger, certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		log:      l.With("cert", certFile, "key", keyFile),
+	}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the key pair from disk and swaps it in if it has changed, the currently served certificate is kept on error
+func (r *certReloader) load() (bool, error) {
+	certPEM, err := ioutil.ReadFile(r.certFile)
+	if err != nil {
+		return false, errors.Wrap(err, "read certificate")
+	}
+	keyPEM, err := ioutil.ReadFile(r.keyFile)
+	if err != nil {
+		return false, errors.Wrap(err, "read key")
+	}
+
+	raw := append(certPEM, keyPEM...)
+	r.mu.RLock()
+	same := bytes.Equal(raw, r.raw)
+	r.mu.RUnlock()
+	if same {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, errors.Wrap(err, "parse x509 key pair")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, errors.Wrap(err, "parse certificate")
+	}
+	cert.Leaf = leaf
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.raw = raw
+	expiry := r.expiry
+	r.mu.Unlock()
+
+	if expiry != nil {
+		expiry.Set(float64(leaf.NotAfter.Unix()))
+	}
+	return true, nil
+}
+
+// recordExpiry exports the served certificate's expiry to g, now and on every reload
+func (r *certReloader) recordExpiry(g prometheus.Gauge) {
+	r.mu.Lock()
+	r.expiry = g
+	leaf := r.cert.Leaf
+	r.mu.Unlock()
+
+	g.Set(float64(leaf.NotAfter.Unix()))
+}
+
+// reload calls load and logs the outcome
+func (r *certReloader) reload() {
+	changed, err := r.load()
+	if err != nil {
+		r.log.Error(errors.WithMessage(err, "reload x509 key pair, keeping current certificate"))
+		return
+	}
+	if changed {
+		r.log.Info("reloaded x509 key pair")
+	}
+}
+
+// watch reloads the key pair every interval, if interval > 0, and whenever SIGHUP is received until the returned stop func is called
+func (r *certReloader) watch(interval time.Duration) (stop func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer signal.Stop(sigs)
+
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-sigs:
+			case <-tick:
+			}
+			r.reload()
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// ReloadX509KeyPair will setup server as a secure server by reading the cert and key from the provided file locations.
+// Unlike LoadX509KeyPair, the files are read again whenever the process receives SIGHUP and every interval (if interval > 0) while the server is serving,
+// so rotated certificates are used for new connections without restarting the server.
+// A failed reload is logged and the last good certificate continues to be served.
+// The served certificate's expiry is exported as the grpc_server_tls_certificate_expiry_timestamp_seconds Prometheus gauge.
+// This function overrides GRPC_CERT and GRPC_KEY environment variables.
+// NewServer will return an error if ReloadX509KeyPair is used along with X509KeyPair or LoadX509KeyPair.
+func ReloadX509KeyPair(certFile, keyFile string, interval time.Duration) Option {
+	return func(s *Server) {
+		if s.cert.PrivateKey != nil {
+			s.err = errors.New("certificate is already set")
+			return
+		}
+
+		r, err := newCertReloader(s.log, certFile, keyFile)
+		if err != nil {
+			s.err = errors.WithMessage(err, "load x509 key pair")
+			return
+		}
+		s.cert = *r.cert
+		s.reloader = r
+		s.reloadInterval = interval
+	}
+}
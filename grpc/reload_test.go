@@ -0,0 +1,147 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/log"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func notAfter(t *testing.T, cert string) float64 {
+	block, _ := pem.Decode([]byte(cert))
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return float64(c.NotAfter.Unix())
+}
+
+func TestReloadX509KeyPair(t *testing.T) {
+	certA, keyA := genCert(t)
+	certB, keyB := genCert(t)
+	certC, keyC := genCert(t)
+
+	t.Run("interval", func(t *testing.T) {
+		assert := require.New(t)
+
+		certFile, keyFile := writeTLSFiles(t, certA, keyA)
+		defer os.Remove(certFile)
+		defer os.Remove(keyFile)
+
+		s, err := NewServer(log.Test(t, svc), defSrv, ReloadX509KeyPair(certFile, keyFile, 10*time.Millisecond))
+		assert.NoError(err)
+
+		serve(t, s, func() {
+			assert.NoError(connectGRPC(t, s.Port(), certA))
+			assert.Error(connectGRPC(t, s.Port(), certB))
+			assert.Equal(notAfter(t, certA), testutil.ToFloat64(defaultMetrics.certExpiry.WithLabelValues(certFile)))
+
+			assert.NoError(ioutil.WriteFile(certFile, []byte(certB), 0600))
+			assert.NoError(ioutil.WriteFile(keyFile, []byte(keyB), 0600))
+			assert.Eventually(func() bool {
+				return connectGRPC(t, s.Port(), certB) == nil
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Error(connectGRPC(t, s.Port(), certA))
+			assert.Equal(notAfter(t, certB), testutil.ToFloat64(defaultMetrics.certExpiry.WithLabelValues(certFile)))
+
+			// a broken key pair is logged and the last good certificate is kept
+			assert.NoError(ioutil.WriteFile(keyFile, []byte(keyC), 0600))
+			time.Sleep(50 * time.Millisecond)
+			assert.NoError(connectGRPC(t, s.Port(), certB))
+		})
+	})
+
+	t.Run("SIGHUP", func(t *testing.T) {
+		assert := require.New(t)
+
+		certFile, keyFile := writeTLSFiles(t, certA, keyA)
+		defer os.Remove(certFile)
+		defer os.Remove(keyFile)
+
+		s, err := NewServer(log.Test(t, svc), defSrv, ReloadX509KeyPair(certFile, keyFile, 0))
+		assert.NoError(err)
+
+		serve(t, s, func() {
+			// SIGHUP is only caught once serving
+			assert.Eventually(func() bool { return s.ready(context.Background()) }, time.Second, time.Millisecond)
+			assert.NoError(connectGRPC(t, s.Port(), certA))
+
+			assert.NoError(ioutil.WriteFile(certFile, []byte(certC), 0600))
+			assert.NoError(ioutil.WriteFile(keyFile, []byte(keyC), 0600))
+			time.Sleep(50 * time.Millisecond)
+			assert.NoError(connectGRPC(t, s.Port(), certA))
+
+			p, err := os.FindProcess(os.Getpid())
+			assert.NoError(err)
+			assert.NoError(p.Signal(syscall.SIGHUP))
+			assert.Eventually(func() bool {
+				return connectGRPC(t, s.Port(), certC) == nil
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	})
+
+	t.Run("only while serving", func(t *testing.T) {
+		assert := require.New(t)
+
+		certFile, keyFile := writeTLSFiles(t, certA, keyA)
+		defer os.Remove(certFile)
+		defer os.Remove(keyFile)
+
+		s, err := NewServer(log.Test(t, svc), defSrv, ReloadX509KeyPair(certFile, keyFile, 10*time.Millisecond))
+		assert.NoError(err)
+		served := func() *tls.Certificate {
+			cert, _ := s.reloader.getCertificate(nil)
+			return cert
+		}
+		before := served()
+
+		// a server that isn't serving doesn't reload
+		assert.NoError(ioutil.WriteFile(certFile, []byte(certB), 0600))
+		assert.NoError(ioutil.WriteFile(keyFile, []byte(keyB), 0600))
+		time.Sleep(50 * time.Millisecond)
+		assert.Same(before, served())
+
+		serve(t, s, func() {
+			assert.Eventually(func() bool { return served() != before }, 5*time.Second, 10*time.Millisecond)
+		})
+
+		// nor does one that has stopped serving
+		after := served()
+		assert.NoError(ioutil.WriteFile(certFile, []byte(certC), 0600))
+		assert.NoError(ioutil.WriteFile(keyFile, []byte(keyC), 0600))
+		time.Sleep(50 * time.Millisecond)
+		assert.Same(after, served())
+	})
+
+	t.Run("assert-fail", func(t *testing.T) {
+		certFile, keyFile := writeTLSFiles(t, certA, keyA)
+		defer os.Remove(certFile)
+		defer os.Remove(keyFile)
+
+		tests := map[string][]Option{
+			"missing files":      {ReloadX509KeyPair(certFile+".missing", keyFile, 0)},
+			"reload then static": {ReloadX509KeyPair(certFile, keyFile, 0), X509KeyPair(certB, keyB)},
+			"already set":        {X509KeyPair(certB, keyB), ReloadX509KeyPair(certFile, keyFile, 0)},
+			"bad key for cert":   {ReloadX509KeyPair(keyFile, certFile, 0)},
+		}
+		for name, opts := range tests {
+			t.Run(name, func(t *testing.T) {
+				s, err := NewServer(log.Test(t, svc), defSrv, opts...)
+				require.Error(t, err)
+				require.Nil(t, s)
+			})
+		}
+	})
+}
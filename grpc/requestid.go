@@ -0,0 +1,103 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/packethost/pkg/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// requestIDHeader is the metadata key the request ID is read from, returned in and forwarded with
+	requestIDHeader = "x-request-id"
+	// maxRequestIDLen is the longest request ID accepted from clients, longer ones are replaced by a generated ID
+	maxRequestIDLen = 128
+)
+
+type ctxRequestID struct{}
+
+// ContextWithRequestID returns a copy of ctx carrying the request ID id, which is forwarded by connections setup with Dial.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxRequestID{}, id)
+}
+
+// RequestIDFromContext returns the request ID of the RPC, as sent by the client in the x-request-id metadata or generated by the server.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ctxRequestID{}).(string)
+	return id, ok && id != ""
+}
+
+// newRequestID returns a random (version 4) UUID
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// incomingRequestID returns the request ID sent by the client, or a new one
+func incomingRequestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDHeader); len(ids) > 0 && ids[0] != "" && len(ids[0]) <= maxRequestIDLen {
+			return ids[0]
+		}
+	}
+	return newRequestID()
+}
+
+// requestIDLogger returns l with the request ID of ctx, if any
+func requestIDLogger(ctx context.Context, l log.Logger) log.Logger {
+	if id, ok := RequestIDFromContext(ctx); ok {
+		return l.WithRequestID(id)
+	}
+	return l
+}
+
+// requestIDUnaryInterceptor makes the request ID available via RequestIDFromContext, returns it in the response header and adds it to the GRPCLoggers log line
+func requestIDUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	id := incomingRequestID(ctx)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+	log.AddGRPCFields(ctx, "request.id", id)
+
+	return handler(ContextWithRequestID(ctx, id), req)
+}
+
+// requestIDStreamInterceptor is the streaming version of requestIDUnaryInterceptor
+func requestIDStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := ss.Context()
+	id := incomingRequestID(ctx)
+	_ = ss.SetHeader(metadata.Pairs(requestIDHeader, id))
+	log.AddGRPCFields(ctx, "request.id", id)
+
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = ContextWithRequestID(ctx, id)
+	return handler(srv, wrapped)
+}
+
+// forwardRequestID returns ctx with the request ID, if any and not already set, added to the outgoing metadata
+func forwardRequestID(ctx context.Context) context.Context {
+	id, ok := RequestIDFromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(requestIDHeader)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, requestIDHeader, id)
+}
+
+func requestIDUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(forwardRequestID(ctx), method, req, reply, cc, opts...)
+}
+
+func requestIDStreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(forwardRequestID(ctx), desc, cc, method, opts...)
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/metadata"
+)
+
+var uuidRE = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+// requestIDServer is a helloworld.GreeterServer that replies with the request ID of the RPC
+type requestIDServer struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (r *requestIDServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	id, _ := RequestIDFromContext(ctx)
+	return &pb.HelloReply{Message: id}, nil
+}
+
+func TestRequestID(t *testing.T) {
+	rt := &recordingT{T: t}
+	assert := require.New(t)
+
+	s, err := NewServer(log.Test(rt, svc), func(s *Server) { pb.RegisterGreeterServer(s.Server(), &requestIDServer{}) })
+	assert.NoError(err)
+
+	tests := []struct {
+		name string
+		sent string
+		want string
+	}{
+		{name: "sent", sent: "req-1234", want: "req-1234"},
+		{name: "generated"},
+		{name: "too long", sent: strings.Repeat("a", maxRequestIDLen+1)},
+	}
+
+	serve(t, s, func() {
+		conn := dialInsecure(t, s.Port())
+		defer conn.Close()
+		greeter := pb.NewGreeterClient(conn)
+
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				assert := require.New(t)
+
+				ctx := context.Background()
+				if test.sent != "" {
+					ctx = metadata.AppendToOutgoingContext(ctx, requestIDHeader, test.sent)
+				}
+				var header metadata.MD
+				r, err := greeter.SayHello(ctx, &pb.HelloRequest{Name: "id"}, grpc.Header(&header))
+				assert.NoError(err)
+
+				if test.want != "" {
+					assert.Equal(test.want, r.Message)
+				} else {
+					assert.Regexp(uuidRE, r.Message)
+				}
+				assert.Equal([]string{r.Message}, header.Get(requestIDHeader))
+				assert.Contains(rt.logged(), fmt.Sprintf(`"request.id": "%s"`, r.Message))
+			})
+		}
+	})
+}
+
+func TestRequestIDDial(t *testing.T) {
+	assert := require.New(t)
+
+	s, err := NewServer(log.Test(t, svc), func(s *Server) { pb.RegisterGreeterServer(s.Server(), &requestIDServer{}) })
+	assert.NoError(err)
+
+	serve(t, s, func() {
+		conn, err := Dial(context.Background(), fmt.Sprintf("localhost:%d", s.Port()), log.Test(t, svc))
+		assert.NoError(err)
+		defer conn.Close()
+		greeter := pb.NewGreeterClient(conn)
+
+		// forwarded from the context
+		ctx := ContextWithRequestID(context.Background(), "fwd-1234")
+		r, err := greeter.SayHello(ctx, &pb.HelloRequest{Name: "id"})
+		assert.NoError(err)
+		assert.Equal("fwd-1234", r.Message)
+
+		// explicit metadata wins
+		r, err = greeter.SayHello(metadata.AppendToOutgoingContext(ctx, requestIDHeader, "md-1234"), &pb.HelloRequest{Name: "id"})
+		assert.NoError(err)
+		assert.Equal("md-1234", r.Message)
+
+		// nothing to forward
+		r, err = greeter.SayHello(context.Background(), &pb.HelloRequest{Name: "id"})
+		assert.NoError(err)
+		assert.Regexp(uuidRE, r.Message)
+	})
+}
+
+func TestRequestIDFromContext(t *testing.T) {
+	assert := require.New(t)
+
+	_, ok := RequestIDFromContext(context.Background())
+	assert.False(ok)
+
+	_, ok = RequestIDFromContext(ContextWithRequestID(context.Background(), ""))
+	assert.False(ok)
+
+	id, ok := RequestIDFromContext(ContextWithRequestID(context.Background(), "req-1234"))
+	assert.True(ok)
+	assert.Equal("req-1234", id)
+
+	assert.NotEqual(newRequestID(), newRequestID())
+}
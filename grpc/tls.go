@@ -0,0 +1,137 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/url"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientCAs will setup the server to verify client certificates (mTLS) against the provided PEM encoded CA certificates.
+// This function overrides the GRPC_CLIENT_CA environment variable.
+// A server certificate must also be configured, see X509KeyPair.
+// NewServer will return an error if both ClientCAs and LoadClientCAs are used.
+func ClientCAs(caPEMBlock string) Option {
+	return func(s *Server) {
+		if s.clientCAs != nil {
+			s.err = errors.New("client CAs are already set")
+			return
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(caPEMBlock)) {
+			s.err = errors.New("parse client CAs: no certificates found")
+			return
+		}
+		s.clientCAs = pool
+	}
+}
+
+// LoadClientCAs will setup the server to verify client certificates (mTLS) against the PEM encoded CA certificates read from caFile.
+// This function overrides the GRPC_CLIENT_CA environment variable.
+// A server certificate must also be configured, see LoadX509KeyPair.
+// NewServer will return an error if both ClientCAs and LoadClientCAs are used.
+func LoadClientCAs(caFile string) Option {
+	return func(s *Server) {
+		if s.clientCAs != nil {
+			s.err = errors.New("client CAs are already set")
+			return
+		}
+
+		b, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			s.err = errors.Wrap(err, "load client CAs")
+			return
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			s.err = errors.New("load client CAs: no certificates found")
+			return
+		}
+		s.clientCAs = pool
+	}
+}
+
+// ClientAuth sets the policy the server follows for client certificates when client CAs are configured.
+// The default is tls.RequireAndVerifyClientCert.
+func ClientAuth(auth tls.ClientAuthType) Option {
+	return func(s *Server) {
+		s.clientAuth = auth
+	}
+}
+
+// MinTLSVersion sets the minimum TLS version the server will accept, version should be one of the tls.VersionTLS* constants.
+// The default is tls.VersionTLS12.
+func MinTLSVersion(version uint16) Option {
+	return func(s *Server) {
+		if version < tls.VersionTLS10 || version > tls.VersionTLS13 {
+			s.err = errors.Errorf("unknown tls version: %#x", version)
+			return
+		}
+
+		s.minTLSVersion = version
+	}
+}
+
+// PeerIdentity is the identity presented by a client in its verified certificate
+type PeerIdentity struct {
+	CommonName     string
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	Certificate    *x509.Certificate
+}
+
+type ctxPeerIdentity struct{}
+
+// PeerIdentityFromContext returns the identity of the client's verified certificate.
+// ok is false if the client did not present a certificate or it was not verified.
+func PeerIdentityFromContext(ctx context.Context) (id *PeerIdentity, ok bool) {
+	id, ok = ctx.Value(ctxPeerIdentity{}).(*PeerIdentity)
+	return id, ok
+}
+
+// contextWithPeerIdentity adds the client's verified certificate identity to ctx, if there is one
+func contextWithPeerIdentity(ctx context.Context) context.Context {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ctx
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ctx
+	}
+
+	cert := info.State.VerifiedChains[0][0]
+	return context.WithValue(ctx, ctxPeerIdentity{}, &PeerIdentity{
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+		Certificate:    cert,
+	})
+}
+
+func peerIdentityUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(contextWithPeerIdentity(ctx), req)
+}
+
+func peerIdentityStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	wrapped := grpc_middleware.WrapServerStream(ss)
+	wrapped.WrappedContext = contextWithPeerIdentity(ss.Context())
+	return handler(srv, wrapped)
+}
@@ -0,0 +1,216 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/packethost/pkg/internal/testenv"
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	pb "google.golang.org/grpc/examples/helloworld/helloworld"
+)
+
+// identityServer is a helloworld.GreeterServer that replies with the common name of the client's certificate
+type identityServer struct {
+	pb.UnimplementedGreeterServer
+}
+
+func (i *identityServer) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	id, ok := PeerIdentityFromContext(ctx)
+	if !ok {
+		return &pb.HelloReply{Message: "Hello stranger"}, nil
+	}
+	return &pb.HelloReply{Message: "Hello " + id.CommonName}, nil
+}
+
+var identitySrv = func(s *Server) {
+	pb.RegisterGreeterServer(s.Server(), &identityServer{})
+}
+
+// genClientCert creates a self signed client certificate that can be used as its own CA
+func genClientCert(t *testing.T, cn string) (string, string) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject: pkix.Name{
+			CommonName:   cn,
+			Organization: []string{"Acme Co"},
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	derBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	if err := pem.Encode(out, &pem.Block{Type: "CERTIFICATE", Bytes: derBytes}); err != nil {
+		t.Fatal(err)
+	}
+	cert := out.String()
+	out.Reset()
+
+	b, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pem.Encode(out, &pem.Block{Type: "EC PRIVATE KEY", Bytes: b}); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert, out.String()
+}
+
+// sayHelloMTLS calls SayHello over tls, presenting the client cert if one is given, and returns the reply message
+func sayHelloMTLS(t *testing.T, port int, serverCert string, clientCert, clientKey string, maxVersion uint16) (string, error) {
+	cp := x509.NewCertPool()
+	if !cp.AppendCertsFromPEM([]byte(serverCert)) {
+		t.Fatal("failed to add cert to pool")
+	}
+	config := &tls.Config{RootCAs: cp, MaxVersion: maxVersion}
+	if clientCert != "" {
+		kp, err := tls.X509KeyPair([]byte(clientCert), []byte(clientKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{kp}
+	}
+
+	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", port), grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		t.Fatalf("did not connect: %v", err)
+	}
+	defer conn.Close()
+
+	reply, err := pb.NewGreeterClient(conn).SayHello(context.Background(), &pb.HelloRequest{Name: t.Name()})
+	if err != nil {
+		return "", err
+	}
+	return reply.Message, nil
+}
+
+func TestMTLS(t *testing.T) {
+	defer testenv.Clear().Restore()
+
+	cert, key := genCert(t)
+	clientCert, clientKey := genClientCert(t, "client-1")
+	otherCert, otherKey := genClientCert(t, "client-2")
+
+	f, err := ioutil.TempFile("", "pkg-grpc-testing-ca-*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err = ioutil.WriteFile(f.Name(), []byte(clientCert), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	requireClientCert := func(t *testing.T, s *Server) {
+		assert := require.New(t)
+		serve(t, s, func() {
+			_, err := sayHelloMTLS(t, s.Port(), cert, "", "", 0)
+			assert.Error(err)
+			_, err = sayHelloMTLS(t, s.Port(), cert, otherCert, otherKey, 0)
+			assert.Error(err)
+			msg, err := sayHelloMTLS(t, s.Port(), cert, clientCert, clientKey, 0)
+			assert.NoError(err)
+			assert.Equal("Hello client-1", msg)
+		})
+	}
+
+	t.Run("ClientCAs", func(t *testing.T) {
+		s, err := NewServer(log.Test(t, svc), identitySrv, X509KeyPair(cert, key), ClientCAs(clientCert))
+		require.NoError(t, err)
+		requireClientCert(t, s)
+	})
+	t.Run("LoadClientCAs", func(t *testing.T) {
+		s, err := NewServer(log.Test(t, svc), identitySrv, X509KeyPair(cert, key), LoadClientCAs(f.Name()))
+		require.NoError(t, err)
+		requireClientCert(t, s)
+	})
+	t.Run("env", func(t *testing.T) {
+		defer testenv.Clear().Restore()
+		os.Setenv("GRPC_CERT", cert)
+		os.Setenv("GRPC_KEY", key)
+		os.Setenv("GRPC_CLIENT_CA", clientCert)
+
+		s, err := NewServer(log.Test(t, svc), identitySrv)
+		require.NoError(t, err)
+		requireClientCert(t, s)
+	})
+	t.Run("ClientAuth", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), identitySrv, X509KeyPair(cert, key), ClientCAs(clientCert), ClientAuth(tls.VerifyClientCertIfGiven))
+		assert.NoError(err)
+		serve(t, s, func() {
+			msg, err := sayHelloMTLS(t, s.Port(), cert, "", "", 0)
+			assert.NoError(err)
+			assert.Equal("Hello stranger", msg)
+			// client-2 is not signed by an acceptable CA so the client does not present it
+			msg, err = sayHelloMTLS(t, s.Port(), cert, otherCert, otherKey, 0)
+			assert.NoError(err)
+			assert.Equal("Hello stranger", msg)
+			msg, err = sayHelloMTLS(t, s.Port(), cert, clientCert, clientKey, 0)
+			assert.NoError(err)
+			assert.Equal("Hello client-1", msg)
+		})
+	})
+	t.Run("MinTLSVersion", func(t *testing.T) {
+		assert := require.New(t)
+
+		s, err := NewServer(log.Test(t, svc), identitySrv, X509KeyPair(cert, key), MinTLSVersion(tls.VersionTLS13))
+		assert.NoError(err)
+		serve(t, s, func() {
+			_, err := sayHelloMTLS(t, s.Port(), cert, "", "", tls.VersionTLS12)
+			assert.Error(err)
+			msg, err := sayHelloMTLS(t, s.Port(), cert, "", "", tls.VersionTLS13)
+			assert.NoError(err)
+			assert.Equal("Hello stranger", msg)
+		})
+	})
+	t.Run("assert-fail", func(t *testing.T) {
+		tests := map[string][]Option{
+			"no server cert":  {ClientCAs(clientCert)},
+			"both CA options": {X509KeyPair(cert, key), ClientCAs(clientCert), LoadClientCAs(f.Name())},
+			"invalid CA":      {X509KeyPair(cert, key), ClientCAs("not a cert")},
+			"missing CA file": {X509KeyPair(cert, key), LoadClientCAs(f.Name() + ".missing")},
+			"unknown version": {X509KeyPair(cert, key), MinTLSVersion(0x42)},
+		}
+		for name, opts := range tests {
+			t.Run(name, func(t *testing.T) {
+				s, err := NewServer(log.Test(t, svc), identitySrv, opts...)
+				require.Error(t, err)
+				require.Nil(t, s)
+			})
+		}
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// tracerName is the instrumentation name of the spans started for streams
+const tracerName = "github.com/packethost/pkg/grpc"
+
+// StreamEventFilter decides whether a message sent or received on a traced stream of fullMethod is recorded as an event on the RPC's span.
+// id counts the messages sent, or received, on the stream starting from 1.
+type StreamEventFilter func(fullMethod string, sent bool, id int) bool
+
+// FirstStreamMessages returns a StreamEventFilter that records the first n messages sent and the first n messages received on each stream.
+func FirstStreamMessages(n int) StreamEventFilter {
+	return func(fullMethod string, sent bool, id int) bool {
+		return id <= n
+	}
+}
+
+// TraceStreams will trace streaming RPCs using the global otel TracerProvider, see the tracing package.
+// Unlike unary RPCs, where both messages are always recorded, only the messages accepted by filter are recorded as span events, a nil filter records none.
+func TraceStreams(filter StreamEventFilter) Option {
+	return func(s *Server) {
+		s.streamTracing = unlessStream(&s.skips.tracing, streamTracingInterceptor(filter))
+	}
+}
+
+// tracedStream records the messages accepted by filter as events on the span of its context
+type tracedStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	method   string
+	filter   StreamEventFilter
+	sent     int
+	received int
+}
+
+func (t *tracedStream) Context() context.Context {
+	return t.ctx
+}
+
+func (t *tracedStream) RecvMsg(m interface{}) error {
+	err := t.ServerStream.RecvMsg(m)
+	if err == nil {
+		t.received++
+		t.event(otelgrpc.RPCMessageTypeReceived, false, t.received, m)
+	}
+	return err
+}
+
+func (t *tracedStream) SendMsg(m interface{}) error {
+	err := t.ServerStream.SendMsg(m)
+	t.sent++
+	t.event(otelgrpc.RPCMessageTypeSent, true, t.sent, m)
+	return err
+}
+
+func (t *tracedStream) event(typ attribute.KeyValue, sent bool, id int, m interface{}) {
+	if t.filter == nil || !t.filter(t.method, sent, id) {
+		return
+	}
+
+	attrs := []attribute.KeyValue{typ, otelgrpc.RPCMessageIDKey.Int(id)}
+	if p, ok := m.(proto.Message); ok {
+		attrs = append(attrs, otelgrpc.RPCMessageUncompressedSizeKey.Int(proto.Size(p)))
+	}
+	trace.SpanFromContext(t.ctx).AddEvent("message", trace.WithAttributes(attrs...))
+}
+
+// streamSpanInfo returns the span name and attributes for fullMethod, following the otelgrpc conventions
+func streamSpanInfo(ctx context.Context, fullMethod string) (string, []attribute.KeyValue) {
+	name := strings.TrimLeft(fullMethod, "/")
+	attrs := []attribute.KeyValue{otelgrpc.RPCSystemGRPC}
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+		attrs = append(attrs, semconv.RPCServiceKey.String(parts[0]), semconv.RPCMethodKey.String(parts[1]))
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, port, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			attrs = append(attrs, semconv.NetPeerIPKey.String(host), semconv.NetPeerPortKey.String(port))
+		}
+	}
+	return name, attrs
+}
+
+func streamTracingInterceptor(filter StreamEventFilter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+
+		md, _ := metadata.FromIncomingContext(ctx)
+		md = md.Copy()
+		bags, sc := otelgrpc.Extract(ctx, &md)
+		ctx = baggage.ContextWithBaggage(ctx, bags)
+
+		name, attrs := streamSpanInfo(ctx, info.FullMethod)
+		ctx, span := otel.Tracer(tracerName).Start(trace.ContextWithRemoteSpanContext(ctx, sc), name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(attrs...),
+		)
+		defer span.End()
+
+		err := handler(srv, &tracedStream{ServerStream: ss, ctx: ctx, method: info.FullMethod, filter: filter})
+
+		st, _ := status.FromError(err)
+		if err != nil {
+			span.SetStatus(otelcodes.Error, st.Message())
+		}
+		span.SetAttributes(otelgrpc.GRPCStatusCodeKey.Int64(int64(st.Code())))
+		return err
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc/codes"
+	echo "google.golang.org/grpc/examples/features/proto/echo"
+	"google.golang.org/grpc/status"
+)
+
+// streamingEchoServer echoes every message of a bidi stream, failing the stream on a "fail" message
+type streamingEchoServer struct {
+	echo.UnimplementedEchoServer
+}
+
+func (s *streamingEchoServer) BidirectionalStreamingEcho(stream echo.Echo_BidirectionalStreamingEchoServer) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if in.Message == "fail" {
+			return status.Error(codes.FailedPrecondition, "failed")
+		}
+		if err := stream.Send(&echo.EchoResponse{Message: in.Message}); err != nil {
+			return err
+		}
+	}
+}
+
+func echoStream(t *testing.T, port int, msgs ...string) error {
+	conn := dialInsecure(t, port)
+	defer conn.Close()
+
+	stream, err := echo.NewEchoClient(conn).BidirectionalStreamingEcho(context.Background())
+	require.NoError(t, err)
+	for _, msg := range msgs {
+		require.NoError(t, stream.Send(&echo.EchoRequest{Message: msg}))
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+	}
+	require.NoError(t, stream.CloseSend())
+	_, err = stream.Recv()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func messageEvents(span sdktrace.ReadOnlySpan) map[attribute.Value][]int64 {
+	events := map[attribute.Value][]int64{}
+	for _, e := range span.Events() {
+		var typ attribute.Value
+		var id int64
+		for _, attr := range e.Attributes {
+			switch attr.Key {
+			case otelgrpc.RPCMessageTypeKey:
+				typ = attr.Value
+			case otelgrpc.RPCMessageIDKey:
+				id = attr.Value.AsInt64()
+			}
+		}
+		events[typ] = append(events[typ], id)
+	}
+	return events
+}
+
+func TestTraceStreams(t *testing.T) {
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	received := otelgrpc.RPCMessageTypeReceived.Value
+	sent := otelgrpc.RPCMessageTypeSent.Value
+	tests := []struct {
+		name   string
+		filter StreamEventFilter
+		msgs   []string
+		code   codes.Code
+		events map[attribute.Value][]int64
+	}{
+		{name: "no events", msgs: []string{"a", "b", "c"}, events: map[attribute.Value][]int64{}},
+		{
+			name:   "first messages",
+			filter: FirstStreamMessages(2),
+			msgs:   []string{"a", "b", "c"},
+			events: map[attribute.Value][]int64{received: {1, 2}, sent: {1, 2}},
+		},
+		{
+			name:   "received only",
+			filter: func(fullMethod string, sent bool, id int) bool { return !sent },
+			msgs:   []string{"a", "b"},
+			events: map[attribute.Value][]int64{received: {1, 2}},
+		},
+		{
+			name:   "error",
+			filter: FirstStreamMessages(1),
+			msgs:   []string{"a", "fail"},
+			code:   codes.FailedPrecondition,
+			events: map[attribute.Value][]int64{received: {1}, sent: {1}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			s, err := NewServer(log.Test(t, svc), func(s *Server) { echo.RegisterEchoServer(s.Server(), &streamingEchoServer{}) },
+				TraceStreams(test.filter),
+			)
+			assert.NoError(err)
+
+			before := len(recorder.Ended())
+			serve(t, s, func() {
+				err := echoStream(t, s.Port(), test.msgs...)
+				assert.Equal(test.code, status.Code(err))
+			})
+
+			spans := recorder.Ended()[before:]
+			assert.Len(spans, 1)
+			span := spans[0]
+			assert.Equal("grpc.examples.echo.Echo/BidirectionalStreamingEcho", span.Name())
+			assert.Equal(test.events, messageEvents(span))
+
+			attrs := attribute.NewSet(span.Attributes()...)
+			code, _ := attrs.Value(otelgrpc.GRPCStatusCodeKey)
+			assert.Equal(int64(test.code), code.AsInt64())
+			method, _ := attrs.Value("rpc.method")
+			assert.Equal("BidirectionalStreamingEcho", method.AsString())
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validator is implemented by messages generated by protoc-gen-validate
+type validator interface {
+	Validate() error
+}
+
+// allValidator is implemented by messages generated by protoc-gen-validate v0.6.2+, reporting all violations instead of the first
+type allValidator interface {
+	ValidateAll() error
+}
+
+// fieldError is implemented by the per message ValidationError types generated by protoc-gen-validate
+type fieldError interface {
+	Field() string
+	Reason() string
+	Cause() error
+}
+
+// multiError is implemented by the per message MultiError types generated by protoc-gen-validate
+type multiError interface {
+	AllErrors() []error
+}
+
+// ValidateRequests will validate incoming messages that have protoc-gen-validate generated ValidateAll or Validate methods before they are handed to the handler.
+// Invalid messages are rejected with codes.InvalidArgument and an errdetails.BadRequest detail listing the field violations.
+func ValidateRequests() Option {
+	return func(s *Server) {
+		s.validate = true
+	}
+}
+
+// validate returns a codes.InvalidArgument status error if msg fails validation
+func validate(msg interface{}) error {
+	var err error
+	switch v := msg.(type) {
+	case allValidator:
+		err = v.ValidateAll()
+	case validator:
+		err = v.Validate()
+	}
+	if err == nil {
+		return nil
+	}
+
+	st := status.New(codes.InvalidArgument, err.Error())
+	violations := fieldViolations("", err)
+	if len(violations) == 0 {
+		return st.Err()
+	}
+	if detailed, derr := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); derr == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// fieldViolations flattens err into field violations, following embedded message errors so fields are reported by their full path, e.g. address.zip
+func fieldViolations(prefix string, err error) []*errdetails.BadRequest_FieldViolation {
+	switch e := err.(type) {
+	case multiError:
+		var violations []*errdetails.BadRequest_FieldViolation
+		for _, err := range e.AllErrors() {
+			violations = append(violations, fieldViolations(prefix, err)...)
+		}
+		return violations
+	case fieldError:
+		field := e.Field()
+		if prefix != "" {
+			field = prefix + "." + field
+		}
+		if e.Cause() != nil {
+			if nested := fieldViolations(field, e.Cause()); len(nested) > 0 {
+				return nested
+			}
+		}
+		return []*errdetails.BadRequest_FieldViolation{{Field: field, Description: e.Reason()}}
+	}
+	return nil
+}
+
+func validateUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := validate(req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// validatingStream validates every message received by the handler
+type validatingStream struct {
+	grpc.ServerStream
+}
+
+func (v *validatingStream) RecvMsg(m interface{}) error {
+	if err := v.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return validate(m)
+}
+
+func validateStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &validatingStream{ss})
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// validationError mimics the per message ValidationError types generated by protoc-gen-validate
+type validationError struct {
+	field  string
+	reason string
+	cause  error
+}
+
+func (e validationError) Field() string  { return e.field }
+func (e validationError) Reason() string { return e.reason }
+func (e validationError) Cause() error   { return e.cause }
+func (e validationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.field, e.reason)
+}
+
+// multiValidationError mimics the per message MultiError types generated by protoc-gen-validate
+type multiValidationError []error
+
+func (m multiValidationError) AllErrors() []error { return m }
+func (m multiValidationError) Error() string {
+	msgs := make([]string, 0, len(m))
+	for _, err := range m {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// onlyValidate is a message that only has the single error Validate method
+type onlyValidate struct {
+	err error
+}
+
+func (o *onlyValidate) Validate() error { return o.err }
+
+// validateAll is a message that has both Validate and ValidateAll methods
+type validateAll struct {
+	onlyValidate
+	all error
+}
+
+func (v *validateAll) ValidateAll() error { return v.all }
+
+// fakeStream is a grpc.ServerStream that receives msgs in order
+type fakeStream struct {
+	grpc.ServerStream
+	msgs []error
+}
+
+func (f *fakeStream) Context() context.Context { return context.Background() }
+
+func (f *fakeStream) RecvMsg(m interface{}) error {
+	if len(f.msgs) == 0 {
+		return errors.New("EOF")
+	}
+	m.(*onlyValidate).err, f.msgs = f.msgs[0], f.msgs[1:]
+	return nil
+}
+
+func violations(t *testing.T, err error) map[string]string {
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+
+	found := map[string]string{}
+	for _, detail := range st.Details() {
+		br, ok := detail.(*errdetails.BadRequest)
+		require.True(t, ok, "unexpected detail %T", detail)
+		for _, v := range br.FieldViolations {
+			found[v.Field] = v.Description
+		}
+	}
+	return found
+}
+
+func TestValidateRequests(t *testing.T) {
+	assert := require.New(t)
+
+	s, err := NewServer(log.Test(t, svc), defSrv)
+	assert.NoError(err)
+	unariers := len(s.unariers)
+
+	s, err = NewServer(log.Test(t, svc), defSrv, ValidateRequests())
+	assert.NoError(err)
+	assert.Len(s.unariers, unariers+1)
+
+	nameErr := validationError{field: "Name", reason: "value length must be at least 1 runes"}
+	zipErr := validationError{field: "Zip", reason: "value does not match regex pattern"}
+	addressErr := validationError{field: "Address", reason: "embedded message failed validation", cause: zipErr}
+
+	tests := []struct {
+		name       string
+		req        interface{}
+		violations map[string]string
+	}{
+		{name: "not a validator", req: "hello"},
+		{name: "valid", req: &onlyValidate{}},
+		{name: "valid all", req: &validateAll{}},
+		{
+			name:       "validate",
+			req:        &onlyValidate{err: nameErr},
+			violations: map[string]string{"Name": nameErr.reason},
+		},
+		{
+			name:       "validate all preferred",
+			req:        &validateAll{onlyValidate: onlyValidate{err: nameErr}, all: multiValidationError{nameErr, zipErr}},
+			violations: map[string]string{"Name": nameErr.reason, "Zip": zipErr.reason},
+		},
+		{
+			name:       "embedded",
+			req:        &onlyValidate{err: addressErr},
+			violations: map[string]string{"Address.Zip": zipErr.reason},
+		},
+		{
+			name: "embedded all",
+			req: &validateAll{all: multiValidationError{
+				nameErr,
+				validationError{field: "Address", reason: "embedded message failed validation", cause: multiValidationError{zipErr}},
+			}},
+			violations: map[string]string{"Name": nameErr.reason, "Address.Zip": zipErr.reason},
+		},
+		{
+			name:       "not a field error",
+			req:        &onlyValidate{err: errors.New("bad request")},
+			violations: map[string]string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			called := false
+			_, err := validateUnaryInterceptor(context.Background(), test.req, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				return nil, nil
+			})
+			if test.violations == nil {
+				assert.NoError(err)
+				assert.True(called)
+				return
+			}
+			assert.False(called)
+			assert.Equal(test.violations, violations(t, err))
+		})
+	}
+
+	t.Run("stream", func(t *testing.T) {
+		assert := require.New(t)
+
+		ss := &fakeStream{msgs: []error{nil, nameErr}}
+		err := validateStreamInterceptor(nil, ss, &grpc.StreamServerInfo{}, func(srv interface{}, stream grpc.ServerStream) error {
+			assert.NoError(stream.RecvMsg(&onlyValidate{}))
+			return stream.RecvMsg(&onlyValidate{})
+		})
+		assert.Equal(map[string]string{"Name": nameErr.reason}, violations(t, err))
+	})
+}
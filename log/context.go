@@ -2,7 +2,9 @@ package log
 
 import (
 	"context"
+	"fmt"
 
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"go.uber.org/zap"
 )
 
@@ -21,3 +23,17 @@ func GetLogger(ctx context.Context) Logger {
 	}
 	return Logger{s: zap.NewNop().Sugar()}
 }
+
+// AddGRPCFields adds the K=V pairs in args as context to the line logged by the GRPCLoggers interceptors when the RPC finishes.
+// It is a no-op if ctx was not handed out by a GRPCLoggers interceptor.
+func AddGRPCFields(ctx context.Context, args ...interface{}) {
+	fields := make([]zap.Field, 0, len(args)/2)
+	for i := 0; i+1 < len(args); i += 2 {
+		key, ok := args[i].(string)
+		if !ok {
+			key = fmt.Sprint(args[i])
+		}
+		fields = append(fields, zap.Any(key, args[i+1]))
+	}
+	ctxzap.AddFields(ctx, fields...)
+}
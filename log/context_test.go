@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"go.uber.org/zap/zaptest/observer"
@@ -73,3 +74,24 @@ func TestContextEmbedding(t *testing.T) {
 		}
 	}
 }
+
+func TestAddGRPCFields(t *testing.T) {
+	enabler := zap.NewAtomicLevelAt(zap.InfoLevel)
+	core, logs := observer.New(enabler)
+
+	// no-op without a grpc logger in the context
+	AddGRPCFields(context.Background(), "foo", "bar")
+
+	ctx := ctxzap.ToContext(context.Background(), zap.New(core))
+	AddGRPCFields(ctx, "foo", "bar", "answer", 42, "dangling")
+	ctxzap.Extract(ctx).Info("finished")
+
+	entries := logs.TakeAll()
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entry count: %d", len(entries))
+	}
+	expected := map[string]interface{}{"foo": "bar", "answer": int64(42)}
+	if !reflect.DeepEqual(expected, entries[0].ContextMap()) {
+		t.Errorf("fields don't match, expected: %v, got: %v", expected, entries[0].ContextMap())
+	}
+}
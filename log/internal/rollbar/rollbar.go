@@ -39,8 +39,12 @@ func Setup(l *zap.SugaredLogger, service string) func() {
 	return rollbar.Wait
 }
 
-func Notify(err error) {
-	rollbar.Error(err)
+func Notify(err error, extras map[string]interface{}) {
+	if extras == nil {
+		rollbar.Error(err)
+		return
+	}
+	rollbar.Error(err, extras)
 }
 
 func getEnvironment() string {
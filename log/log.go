@@ -21,9 +21,10 @@ var (
 
 // Logger is a wrapper around zap.SugaredLogger
 type Logger struct {
-	service string
-	s       *zap.SugaredLogger
-	cleanup func()
+	service   string
+	s         *zap.SugaredLogger
+	cleanup   func()
+	requestID string
 }
 
 func setupConfig(service string) zap.Config {
@@ -107,7 +108,11 @@ func (l Logger) Error(err error, args ...interface{}) {
 		return
 	}
 	if ok := os.Getenv("ROLLBAR_TOKEN"); ok != "" {
-		rollbar.Notify(err)
+		var extras map[string]interface{}
+		if l.requestID != "" {
+			extras = map[string]interface{}{"request_id": l.requestID}
+		}
+		rollbar.Notify(err, extras)
 	}
 	if len(args) == 0 {
 		args = append(args, err)
@@ -137,20 +142,27 @@ func (l Logger) Debug(args ...interface{}) {
 
 // With is used to add context to the logger, a new logger copy with the new K=V pairs as context is returned.
 func (l Logger) With(args ...interface{}) Logger {
-	return Logger{service: l.service, s: l.s.With(args...), cleanup: l.cleanup}
+	return Logger{service: l.service, s: l.s.With(args...), cleanup: l.cleanup, requestID: l.requestID}
+}
+
+// WithRequestID returns a copy of the logger with "request.id" set to id, errors logged by the copy are reported to rollbar with the request_id extra.
+func (l Logger) WithRequestID(id string) Logger {
+	logger := l.With("request.id", id)
+	logger.requestID = id
+	return logger
 }
 
 // AddCallerSkip increases the number of callers skipped by caller annotation.
 // When building wrappers around the Logger, supplying this option prevents Logger from always reporting the wrapper code as the caller.
 func (l Logger) AddCallerSkip(skip int) Logger {
 	s := l.s.Desugar().WithOptions(zap.AddCallerSkip(skip)).Sugar()
-	return Logger{service: l.service, s: s, cleanup: l.cleanup}
+	return Logger{service: l.service, s: s, cleanup: l.cleanup, requestID: l.requestID}
 }
 
 // Package returns a copy of the logger with the "pkg" set to the argument.
 // It should be called before the original Logger has had any keys set to values, otherwise confusion may ensue.
 func (l Logger) Package(pkg string) Logger {
-	return Logger{service: l.service, s: l.s.With("pkg", pkg), cleanup: l.cleanup}
+	return Logger{service: l.service, s: l.s.With("pkg", pkg), cleanup: l.cleanup, requestID: l.requestID}
 }
 
 // GRPCLoggers returns server side logging middleware for gRPC servers
@@ -158,3 +170,9 @@ func (l Logger) GRPCLoggers() (grpc.StreamServerInterceptor, grpc.UnaryServerInt
 	logger := l.s.Desugar()
 	return grpc_zap.StreamServerInterceptor(logger), grpc_zap.UnaryServerInterceptor(logger)
 }
+
+// GRPCClientLoggers returns client side logging middleware for gRPC clients
+func (l Logger) GRPCClientLoggers() (grpc.StreamClientInterceptor, grpc.UnaryClientInterceptor) {
+	logger := l.s.Desugar()
+	return grpc_zap.StreamClientInterceptor(logger), grpc_zap.UnaryClientInterceptor(logger)
+}
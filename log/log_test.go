@@ -161,6 +161,36 @@ func TestContext(t *testing.T) {
 	}
 }
 
+func TestWithRequestID(t *testing.T) {
+	enabler := zap.NewAtomicLevelAt(zap.InfoLevel)
+	core, logs := observer.New(enabler)
+
+	logger, err := configureLogger(zap.New(core), "testing-request-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.Close()
+
+	if logger.requestID != "" {
+		t.Fatalf("unexpected request id: %s", logger.requestID)
+	}
+
+	// the request id survives further copies of the logger
+	logger = logger.WithRequestID("req-1234").With("foo", "bar").Package("request").AddCallerSkip(1)
+	if logger.requestID != "req-1234" {
+		t.Fatalf("unexpected request id: want=%s, got=%s", "req-1234", logger.requestID)
+	}
+
+	logger.Info("info")
+	msgs := logs.All()
+	if len(msgs) != 1 {
+		t.Fatalf("unexpected number of messages: want=%d, got=%d", 1, len(msgs))
+	}
+	if got := msgs[0].ContextMap()["request.id"]; got != "req-1234" {
+		t.Fatalf("unexpected request.id: want=%s, got=%v", "req-1234", got)
+	}
+}
+
 func TestInit(t *testing.T) {
 	defer testenv.Clear().Restore()
 
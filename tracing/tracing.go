@@ -0,0 +1,191 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+// Package tracing sets up OpenTelemetry tracing, configured via the standard OTEL_* environment variables.
+package tracing
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/packethost/pkg/env"
+	"github.com/packethost/pkg/log"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// closeTimeout is how long Close waits for buffered spans to be exported
+const closeTimeout = 5 * time.Second
+
+// Provider is a wrapper around the sdk's TracerProvider
+type Provider struct {
+	tp  *sdktrace.TracerProvider
+	log log.Logger
+}
+
+// config is used to hold the info configured via Option funcs for Init
+type config struct {
+	exporter sdktrace.SpanExporter
+	sampler  sdktrace.Sampler
+}
+
+// The Option type describes functions that operate on the tracing setup during Init.
+type Option func(*config)
+
+// Exporter sets the exporter spans are sent to, overriding OTEL_TRACES_EXPORTER.
+func Exporter(e sdktrace.SpanExporter) Option {
+	return func(c *config) {
+		c.exporter = e
+	}
+}
+
+// Sampler sets the sampler used for new traces, overriding OTEL_TRACES_SAMPLER.
+func Sampler(s sdktrace.Sampler) Option {
+	return func(c *config) {
+		c.sampler = s
+	}
+}
+
+// Init sets up a TracerProvider for service and registers it, and the W3C trace context and baggage propagators, as the otel globals.
+// The grpc package's interceptors, and anything else using the otel globals, will then record spans.
+//
+// The exporter is configured via OTEL_TRACES_EXPORTER, one of otlp (default), stdout or none.
+// The otlp exporter uses the protocol in OTEL_EXPORTER_OTLP_TRACES_PROTOCOL or OTEL_EXPORTER_OTLP_PROTOCOL, one of grpc (default) or http/protobuf,
+// and is otherwise configured via the standard OTEL_EXPORTER_OTLP_* env variables, e.g. OTEL_EXPORTER_OTLP_ENDPOINT.
+// The sampler is configured via OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG, it defaults to parentbased_traceidratio sampling every trace.
+// The service name, and other resource attributes, can be overriden via OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES.
+//
+// Close should be called before the program exits so buffered spans are exported.
+func Init(service string, l log.Logger, options ...Option) (Provider, error) {
+	c := &config{}
+	for _, opt := range options {
+		opt(c)
+	}
+
+	if c.sampler == nil {
+		sampler, err := samplerFromEnv()
+		if err != nil {
+			return Provider{}, err
+		}
+		c.sampler = sampler
+	}
+
+	if c.exporter == nil {
+		exporter, err := exporterFromEnv()
+		if err != nil {
+			return Provider{}, err
+		}
+		c.exporter = exporter
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceNameKey.String(service)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return Provider{}, errors.Wrap(err, "setup tracing resource")
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(c.sampler),
+		sdktrace.WithResource(res),
+	}
+	if c.exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(c.exporter))
+	}
+	tp := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return Provider{tp: tp, log: l}, nil
+}
+
+// TracerProvider returns the underlying TracerProvider, for use where the global one is not wanted.
+func (p Provider) TracerProvider() trace.TracerProvider {
+	return p.tp
+}
+
+// Close finishes and flushes any buffered spans and shuts down the exporter.
+func (p Provider) Close() {
+	if p.tp == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	if err := p.tp.Shutdown(ctx); err != nil {
+		p.log.Error(errors.Wrap(err, "shutdown tracer provider"))
+	}
+}
+
+// exporterFromEnv returns the exporter configured via OTEL_TRACES_EXPORTER, nil means spans are not exported
+func exporterFromEnv() (sdktrace.SpanExporter, error) {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+
+	switch name := env.Get("OTEL_TRACES_EXPORTER", "otlp"); name {
+	case "none":
+		return nil, nil
+	case "stdout", "console":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		switch protocol := env.Get("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", env.Get("OTEL_EXPORTER_OTLP_PROTOCOL", "grpc")); protocol {
+		case "grpc":
+			exporter, err = otlptracegrpc.New(context.Background())
+		case "http/protobuf":
+			exporter, err = otlptracehttp.New(context.Background())
+		default:
+			return nil, errors.Errorf("unsupported otlp protocol %q", protocol)
+		}
+	default:
+		return nil, errors.Errorf("unsupported traces exporter %q", name)
+	}
+
+	if err != nil {
+		return nil, errors.Wrap(err, "setup traces exporter")
+	}
+	return exporter, nil
+}
+
+// samplerFromEnv returns the sampler configured via OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG
+func samplerFromEnv() (sdktrace.Sampler, error) {
+	ratio := 1.0
+	if arg := env.Get("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+		var err error
+		ratio, err = strconv.ParseFloat(arg, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return nil, errors.Errorf("OTEL_TRACES_SAMPLER_ARG must be a ratio between 0 and 1, got %q", arg)
+		}
+	}
+
+	switch name := env.Get("OTEL_TRACES_SAMPLER", "parentbased_traceidratio"); name {
+	case "always_on":
+		return sdktrace.AlwaysSample(), nil
+	case "always_off":
+		return sdktrace.NeverSample(), nil
+	case "traceidratio":
+		return sdktrace.TraceIDRatioBased(ratio), nil
+	case "parentbased_always_on":
+		return sdktrace.ParentBased(sdktrace.AlwaysSample()), nil
+	case "parentbased_always_off":
+		return sdktrace.ParentBased(sdktrace.NeverSample()), nil
+	case "parentbased_traceidratio":
+		return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio)), nil
+	default:
+		return nil, errors.Errorf("unsupported traces sampler %q", name)
+	}
+}
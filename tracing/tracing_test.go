@@ -0,0 +1,183 @@
+// Copyright 2021 - 2021, Packethost, Inc and contributors
+// SPDX-License-Identifier: Apache-2.0
+
+package tracing
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/packethost/pkg/internal/testenv"
+	"github.com/packethost/pkg/log"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+)
+
+func TestExporterFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		exporter interface{}
+		fail     bool
+	}{
+		{name: "default", exporter: &otlptrace.Exporter{}},
+		{name: "otlp grpc", env: map[string]string{"OTEL_TRACES_EXPORTER": "otlp", "OTEL_EXPORTER_OTLP_PROTOCOL": "grpc"}, exporter: &otlptrace.Exporter{}},
+		{name: "otlp http", env: map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "http/protobuf"}, exporter: &otlptrace.Exporter{}},
+		{name: "otlp traces protocol", env: map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "bogus", "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL": "http/protobuf"}, exporter: &otlptrace.Exporter{}},
+		{name: "stdout", env: map[string]string{"OTEL_TRACES_EXPORTER": "stdout"}, exporter: &stdouttrace.Exporter{}},
+		{name: "none", env: map[string]string{"OTEL_TRACES_EXPORTER": "none"}},
+		{name: "unknown exporter", env: map[string]string{"OTEL_TRACES_EXPORTER": "zipkin"}, fail: true},
+		{name: "unknown protocol", env: map[string]string{"OTEL_EXPORTER_OTLP_PROTOCOL": "http/json"}, fail: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer testenv.Clear().Restore()
+			assert := require.New(t)
+
+			for k, v := range test.env {
+				os.Setenv(k, v)
+			}
+
+			exporter, err := exporterFromEnv()
+			if test.fail {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			if test.exporter == nil {
+				assert.Nil(exporter)
+				return
+			}
+			assert.IsType(test.exporter, exporter)
+			assert.NoError(exporter.Shutdown(context.Background()))
+		})
+	}
+}
+
+func TestSamplerFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		sampler string
+		arg     string
+		want    sdktrace.Sampler
+	}{
+		{name: "default", want: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(1))},
+		{name: "default with ratio", arg: "0.25", want: sdktrace.ParentBased(sdktrace.TraceIDRatioBased(0.25))},
+		{name: "always_on", sampler: "always_on", want: sdktrace.AlwaysSample()},
+		{name: "always_off", sampler: "always_off", want: sdktrace.NeverSample()},
+		{name: "traceidratio", sampler: "traceidratio", arg: "0.5", want: sdktrace.TraceIDRatioBased(0.5)},
+		{name: "parentbased_always_on", sampler: "parentbased_always_on", want: sdktrace.ParentBased(sdktrace.AlwaysSample())},
+		{name: "parentbased_always_off", sampler: "parentbased_always_off", want: sdktrace.ParentBased(sdktrace.NeverSample())},
+		{name: "unknown", sampler: "jaeger_remote"},
+		{name: "bad ratio", arg: "lots"},
+		{name: "ratio too big", arg: "1.5"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer testenv.Clear().Restore()
+			assert := require.New(t)
+
+			if test.sampler != "" {
+				os.Setenv("OTEL_TRACES_SAMPLER", test.sampler)
+			}
+			if test.arg != "" {
+				os.Setenv("OTEL_TRACES_SAMPLER_ARG", test.arg)
+			}
+
+			sampler, err := samplerFromEnv()
+			if test.want == nil {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+			assert.Equal(test.want.Description(), sampler.Description())
+		})
+	}
+}
+
+// keepingExporter is an in memory exporter that keeps its spans after being shutdown
+type keepingExporter struct {
+	*tracetest.InMemoryExporter
+}
+
+func (k keepingExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func TestInit(t *testing.T) {
+	defer testenv.Clear().Restore()
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	assert := require.New(t)
+
+	os.Setenv("OTEL_TRACES_SAMPLER", "bogus")
+	_, err := Init("testing", log.Test(t, "testing"))
+	assert.Error(err)
+
+	os.Setenv("OTEL_TRACES_SAMPLER", "always_on")
+	os.Setenv("OTEL_TRACES_EXPORTER", "bogus")
+	_, err = Init("testing", log.Test(t, "testing"))
+	assert.Error(err)
+
+	os.Setenv("OTEL_RESOURCE_ATTRIBUTES", "deployment.environment=testing")
+	exporter := keepingExporter{tracetest.NewInMemoryExporter()}
+	p, err := Init("testing", log.Test(t, "testing"), Exporter(exporter))
+	assert.NoError(err)
+	assert.Equal(p.TracerProvider(), otel.GetTracerProvider())
+
+	_, span := otel.Tracer("testing").Start(context.Background(), "span")
+	assert.True(span.SpanContext().IsSampled())
+	span.End()
+
+	// spans are batched until Close
+	assert.Empty(exporter.GetSpans())
+	p.Close()
+
+	spans := exporter.GetSpans()
+	assert.Len(spans, 1)
+	assert.Equal("span", spans[0].Name)
+	attrs := spans[0].Resource.Set()
+	name, _ := attrs.Value(semconv.ServiceNameKey)
+	assert.Equal("testing", name.AsString())
+	deployment, _ := attrs.Value("deployment.environment")
+	assert.Equal("testing", deployment.AsString())
+
+	// the sampler option wins over the env
+	exporter.Reset()
+	p, err = Init("testing", log.Test(t, "testing"), Exporter(exporter), Sampler(sdktrace.NeverSample()))
+	assert.NoError(err)
+
+	_, span = otel.Tracer("testing").Start(context.Background(), "unsampled")
+	assert.False(span.SpanContext().IsSampled())
+	span.End()
+	p.Close()
+	assert.Empty(exporter.GetSpans())
+
+	// closing a zero Provider is a no-op
+	Provider{}.Close()
+}
+
+func TestInitServiceNameFromEnv(t *testing.T) {
+	defer testenv.Clear().Restore()
+	defer otel.SetTracerProvider(otel.GetTracerProvider())
+	assert := require.New(t)
+
+	os.Setenv("OTEL_SERVICE_NAME", "from-env")
+	exporter := keepingExporter{tracetest.NewInMemoryExporter()}
+	p, err := Init("testing", log.Test(t, "testing"), Exporter(exporter))
+	assert.NoError(err)
+
+	_, span := otel.Tracer("testing").Start(context.Background(), "span")
+	span.End()
+	p.Close()
+
+	spans := exporter.GetSpans()
+	assert.Len(spans, 1)
+	name, _ := spans[0].Resource.Set().Value(semconv.ServiceNameKey)
+	assert.Equal("from-env", name.AsString())
+}